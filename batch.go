@@ -0,0 +1,458 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/InoiOy/go-graphql-client/internal/jsonutil"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+// BatchOperation is a single GraphQL operation sent as part of a batch.
+type BatchOperation struct {
+	Type OperationType
+	Name string // Operation name. Optional.
+
+	// Value is a pointer to struct that corresponds to the GraphQL schema.
+	// The operation is derived from it, and the response is populated into it.
+	Value     interface{}
+	Variables interface{}
+}
+
+// Batch executes several GraphQL operations in a single HTTP request,
+// whose body is a JSON array of operations. The server is expected to
+// respond with a JSON array holding the response of each operation, in order.
+//
+// The returned slice holds the error of each operation, in the order of ops,
+// nil for operations that succeeded.
+//
+// Each operation passes through the middleware chain on its own. Operations
+// whose headers end up different are sent in separate HTTP requests. If one
+// of them fails, e.g. with a non-200 OK status code, its error is the error
+// of each operation it carried, while operations sent in the other requests
+// are unaffected. The returned error is then the error of the first request
+// that failed.
+func (c *Client) Batch(ctx context.Context, ops []BatchOperation) ([]error, error) {
+	reqs := make([]*Request, len(ops))
+	for i, op := range ops {
+		req, err := c.newRequest(op.Type, op.Value, op.Variables, op.Name)
+		if err != nil {
+			return nil, err
+		}
+		reqs[i] = req
+	}
+
+	b := &batch{c: c, pending: len(ops)}
+	errs := make([]error, len(ops))
+	var wg sync.WaitGroup
+	wg.Add(len(ops))
+	for i := range ops {
+		go func(i int) {
+			defer wg.Done()
+			joined := false
+			h := c.handler(func(ctx context.Context, req *Request) (*Response, error) {
+				if joined {
+					// Called again by a middleware, after the batch was sent.
+					return c.send(ctx, req)
+				}
+				joined = true
+				return b.send(ctx, i, req)
+			})
+			resp, err := h(ctx, reqs[i])
+			if !joined {
+				b.leave()
+			}
+			errs[i] = decodeResponse(resp, err, ops[i].Value)
+		}(i)
+	}
+	wg.Wait()
+	return errs, b.err
+}
+
+// newRequest constructs the request of a single GraphQL operation derived from v.
+func (c *Client) newRequest(op OperationType, v interface{}, variables interface{}, name string) (*Request, error) {
+	vars, err := variablesMap(variables)
+	if err != nil {
+		return nil, err
+	}
+	req := &Request{
+		Variables:     payloadVariables(vars),
+		OperationName: name,
+		Type:          op,
+		Header:        make(http.Header),
+	}
+	if c.persistedQueries != nil {
+		req.persisted, err = c.persistedDocument(op, v, vars, name)
+		if err != nil {
+			return nil, err
+		}
+		req.Query = req.persisted.query
+		return req, nil
+	}
+	req.Query, err = constructOperation(op, v, vars, name, c.types)
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// constructOperation returns the document of operation op on struct v.
+func constructOperation(op OperationType, v interface{}, variables map[string]interface{}, name string, types typeNames) (string, error) {
+	switch op {
+	case QueryOperation:
+		return constructQuery(v, variables, name, types)
+	case MutationOperation:
+		return constructMutation(v, variables, name, types)
+	default:
+		return "", fmt.Errorf("unsupported operation type: %v", op)
+	}
+}
+
+// decodeResponse unmarshals the data of resp into v, and returns
+// err, the unmarshaling error or the GraphQL errors of resp, in that order.
+func decodeResponse(resp *Response, err error, v interface{}) error {
+	if err != nil {
+		return err
+	}
+	if resp.Data != nil && v != nil {
+		unmarshal := jsonutil.UnmarshalGraphQL
+		if !isQueryStruct(v) {
+			unmarshal = json.Unmarshal
+		}
+		err := unmarshal(*resp.Data, v)
+		if err != nil {
+			// TODO: Consider including response body in returned error, if deemed helpful.
+			return err
+		}
+	}
+	if len(resp.Errors) > 0 {
+		return resp.Errors
+	}
+	return nil
+}
+
+// isQueryStruct reports whether v is a pointer to a GraphQL query struct,
+// as opposed to a pointer to map or other plain JSON value.
+func isQueryStruct(v interface{}) bool {
+	t := reflect.TypeOf(v)
+	return t != nil && t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct
+}
+
+// batch collects requests to be sent together in a single HTTP request.
+type batch struct {
+	c *Client
+
+	// unwrapSingle causes a batch of a single request to be sent
+	// as a regular, non-batched request.
+	unwrapSingle bool
+
+	mu      sync.Mutex
+	pending int // Number of operations expected to join or leave before the batch is flushed.
+	items   []*batchItem
+	flushed bool
+	err     error // Error of the first HTTP request of the batch that failed.
+}
+
+type batchItem struct {
+	ctx    context.Context
+	index  int // Position of the operation in the batch.
+	req    *Request
+	result chan batchResult
+}
+
+type batchResult struct {
+	resp *Response
+	err  error
+}
+
+// add adds req to the batch at position index.
+// It returns nil if the batch was already flushed.
+func (b *batch) add(ctx context.Context, index int, req *Request) *batchItem {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.flushed {
+		return nil
+	}
+	item := &batchItem{ctx: ctx, index: index, req: req, result: make(chan batchResult, 1)}
+	b.items = append(b.items, item)
+	return item
+}
+
+// wait waits for the response of item, or for ctx to be done.
+func (b *batch) wait(ctx context.Context, item *batchItem) (*Response, error) {
+	select {
+	case r := <-item.result:
+		return r.resp, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// send adds req to the batch at position index, and waits for its response.
+// The batch is flushed once all expected operations have joined or left.
+func (b *batch) send(ctx context.Context, index int, req *Request) (*Response, error) {
+	item := b.add(ctx, index, req)
+	b.mu.Lock()
+	b.pending--
+	b.mu.Unlock()
+	b.flushIfReady()
+	return b.wait(ctx, item)
+}
+
+// leave reports that an operation returned without joining the batch,
+// e.g. because a middleware responded to it directly.
+func (b *batch) leave() {
+	b.mu.Lock()
+	b.pending--
+	b.mu.Unlock()
+	b.flushIfReady()
+}
+
+// flushIfReady flushes the batch if no more operations are expected to join it.
+func (b *batch) flushIfReady() {
+	b.mu.Lock()
+	ready := b.pending <= 0 && len(b.items) > 0
+	b.mu.Unlock()
+	if ready {
+		b.flush()
+	}
+}
+
+// flush sends the requests collected so far, and delivers their responses.
+// Subsequent calls do nothing.
+//
+// The batched request is canceled only once the contexts of all its
+// operations are done, so that canceling one operation doesn't affect the others.
+func (b *batch) flush() {
+	b.mu.Lock()
+	if b.flushed {
+		b.mu.Unlock()
+		return
+	}
+	b.flushed = true
+	items := b.items
+	b.mu.Unlock()
+	if len(items) == 0 {
+		return
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].index < items[j].index })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for _, item := range items {
+			select {
+			case <-item.ctx.Done():
+			case <-ctx.Done():
+				return
+			}
+		}
+		cancel()
+	}()
+
+	// Operations are only batched with those sending the same headers,
+	// which may carry the credentials of different callers.
+	groups := groupByHeader(items)
+	var wg sync.WaitGroup
+	for _, group := range groups {
+		wg.Add(1)
+		go func(items []*batchItem) {
+			defer wg.Done()
+			b.sendGroup(ctx, items)
+		}(group)
+	}
+	wg.Wait()
+}
+
+// sendGroup sends items, sharing the same headers, in a single request,
+// and delivers their responses.
+func (b *batch) sendGroup(ctx context.Context, items []*batchItem) {
+	if b.unwrapSingle && len(items) == 1 {
+		resp, err := b.c.sendOne(ctx, items[0].req)
+		items[0].result <- batchResult{resp: resp, err: err}
+		return
+	}
+
+	reqs := make([]*Request, len(items))
+	for i, item := range items {
+		reqs[i] = item.req
+	}
+	resps, err := b.c.sendBatch(ctx, reqs)
+	if err != nil {
+		b.mu.Lock()
+		if b.err == nil {
+			b.err = err
+		}
+		b.mu.Unlock()
+	}
+	for i, item := range items {
+		if err != nil {
+			item.result <- batchResult{err: err}
+			continue
+		}
+		item.result <- batchResult{resp: resps[i]}
+	}
+}
+
+// groupByHeader groups items by the headers of their requests,
+// keeping their order.
+func groupByHeader(items []*batchItem) [][]*batchItem {
+	var groups [][]*batchItem
+	index := make(map[string]int) // Header key -> group index.
+	for _, item := range items {
+		key := headerKey(item.req.Header)
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], item)
+	}
+	return groups
+}
+
+// headerKey returns a string identifying header h.
+func headerKey(h http.Header) string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(strconv.Quote(k))
+		for _, v := range h[k] {
+			b.WriteString(" " + strconv.Quote(v))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// batcher implements automatic batching: it collects queries sent
+// concurrently within a time window into a single batched request.
+type batcher struct {
+	c       *Client
+	window  time.Duration
+	maxSize int
+
+	mu      sync.Mutex
+	current *batch // Batch currently collecting queries, if any.
+	size    int    // Number of queries in current.
+}
+
+// WithBatching enables automatic batching of queries. Queries executed
+// concurrently, e.g. from several goroutines, are collected and sent as a
+// single batched request once window has elapsed since the first of them,
+// or once maxSize queries were collected. If maxSize is zero or negative,
+// the size of batches isn't limited.
+//
+// Callers keep using Query and its variants unchanged. Each caller's
+// context only affects its own query. Queries are only batched with those
+// sending the same headers, e.g. set by middlewares, so that the headers of
+// a caller aren't sent for the queries of others. Mutations are never batched.
+func (c *Client) WithBatching(window time.Duration, maxSize int) *Client {
+	c.batcher = &batcher{c: c, window: window, maxSize: maxSize}
+	return c
+}
+
+// send adds req to the current batch, starting a new one if needed,
+// and waits for its response.
+func (bt *batcher) send(ctx context.Context, req *Request) (*Response, error) {
+	bt.mu.Lock()
+	b := bt.current
+	if b == nil {
+		b = &batch{c: bt.c, unwrapSingle: true}
+		bt.current, bt.size = b, 0
+		time.AfterFunc(bt.window, func() { bt.flush(b) })
+	}
+	item := b.add(ctx, bt.size, req)
+	bt.size++
+	full := bt.maxSize > 0 && bt.size >= bt.maxSize
+	bt.mu.Unlock()
+
+	if full {
+		bt.flush(b)
+	}
+	return b.wait(ctx, item)
+}
+
+// flush flushes b, if it's still the current batch.
+func (bt *batcher) flush(b *batch) {
+	bt.mu.Lock()
+	if bt.current == b {
+		bt.current = nil
+	}
+	bt.mu.Unlock()
+	b.flush()
+}
+
+// sendBatch sends reqs as a JSON array in the body of a single POST request,
+// and decodes the array of response envelopes.
+func (c *Client) sendBatch(ctx context.Context, reqs []*Request) ([]*Response, error) {
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(reqs)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, c.url, &buf)
+	if err != nil {
+		return nil, err
+	}
+	// Batched requests share the same headers.
+	for k, v := range reqs[0].Header {
+		httpReq.Header[k] = v
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	c.trace(ctx, nil, TraceEvent{Kind: TraceHTTPSend, Size: httpReq.ContentLength})
+	start := time.Now()
+	resp, err := ctxhttp.Do(ctx, c.httpClient, httpReq)
+	if err != nil {
+		c.trace(ctx, nil, TraceEvent{Kind: TraceHTTPReceive, Duration: time.Since(start), Err: err})
+		return nil, err
+	}
+	defer resp.Body.Close()
+	c.trace(ctx, nil, TraceEvent{Kind: TraceHTTPReceive, Duration: time.Since(start), StatusCode: resp.StatusCode, Size: resp.ContentLength})
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("non-200 OK status code: %v body: %q", resp.Status, body)
+	}
+	var envelopes []struct {
+		Data       *json.RawMessage
+		Errors     Errors
+		Extensions map[string]interface{}
+	}
+	start = time.Now()
+	body := &countingReader{r: resp.Body}
+	err = json.NewDecoder(body).Decode(&envelopes)
+	c.trace(ctx, nil, TraceEvent{Kind: TraceDecode, Duration: time.Since(start), Size: body.n, Err: err})
+	if err != nil {
+		// TODO: Consider including response body in returned error, if deemed helpful.
+		return nil, err
+	}
+	if len(envelopes) != len(reqs) {
+		return nil, fmt.Errorf("batched response has %d results, want %d", len(envelopes), len(reqs))
+	}
+	out := make([]*Response, len(envelopes))
+	for i, e := range envelopes {
+		out[i] = &Response{
+			Data:       e.Data,
+			Errors:     e.Errors,
+			Extensions: e.Extensions,
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header,
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,367 @@
+package graphql_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/InoiOy/go-graphql-client"
+)
+
+func TestClient_Batch(t *testing.T) {
+	var requests int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		var in []struct {
+			Query         string
+			Variables     map[string]interface{}
+			OperationName string
+		}
+		if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(in), 2; got != want {
+			t.Fatalf("got %d operations, want: %d", got, want)
+		}
+		if got, want := in[0].Query, `query GetUser($login:String!){user(login: $login){name}}`; got != want {
+			t.Errorf("got in[0].Query: %q, want: %q", got, want)
+		}
+		if got, want := in[1].Query, `{viewer{login}}`; got != want {
+			t.Errorf("got in[1].Query: %q, want: %q", got, want)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `[
+			{"data": {"user": {"name": "Gopher"}}},
+			{"data": null, "errors": [{"message": "not authenticated"}]}
+		]`)
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}})
+
+	var user struct {
+		User struct {
+			Name graphql.String
+		} `graphql:"user(login: $login)"`
+	}
+	var viewer struct {
+		Viewer struct {
+			Login graphql.String
+		}
+	}
+	errs, err := client.Batch(context.Background(), []graphql.BatchOperation{
+		{Type: graphql.QueryOperation, Name: "GetUser", Value: &user, Variables: map[string]interface{}{"login": graphql.String("gopher")}},
+		{Type: graphql.QueryOperation, Value: &viewer},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := requests, 1; got != want {
+		t.Errorf("got %d requests, want: %d", got, want)
+	}
+	if errs[0] != nil {
+		t.Errorf("got errs[0]: %v, want: nil", errs[0])
+	}
+	if got, want := user.User.Name, graphql.String("Gopher"); got != want {
+		t.Errorf("got user.User.Name: %q, want: %q", got, want)
+	}
+	if errs[1] == nil || errs[1].Error() != "not authenticated" {
+		t.Errorf("got errs[1]: %v, want: not authenticated", errs[1])
+	}
+}
+
+func TestClient_Batch_middlewareResponds(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		var in []json.RawMessage
+		if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(in), 1; got != want {
+			t.Errorf("got %d operations, want: %d", got, want)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `[{"data": {"user": {"name": "Gopher"}}}]`)
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}}).
+		WithMiddleware(func(next graphql.Handler) graphql.Handler {
+			return func(ctx context.Context, req *graphql.Request) (*graphql.Response, error) {
+				if req.OperationName == "Cached" {
+					data := json.RawMessage(`{"user": {"name": "Cached"}}`)
+					return &graphql.Response{Data: &data}, nil
+				}
+				return next(ctx, req)
+			}
+		})
+
+	var q1, q2 struct {
+		User struct {
+			Name graphql.String
+		}
+	}
+	errs, err := client.Batch(context.Background(), []graphql.BatchOperation{
+		{Type: graphql.QueryOperation, Name: "Cached", Value: &q1},
+		{Type: graphql.QueryOperation, Value: &q2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("got errs[%d]: %v, want: nil", i, err)
+		}
+	}
+	if got, want := q1.User.Name, graphql.String("Cached"); got != want {
+		t.Errorf("got q1.User.Name: %q, want: %q", got, want)
+	}
+	if got, want := q2.User.Name, graphql.String("Gopher"); got != want {
+		t.Errorf("got q2.User.Name: %q, want: %q", got, want)
+	}
+}
+
+// Test that when one of the requests carrying a batch fails, only the
+// operations it carried get its error.
+func TestClient_Batch_headersFailure(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `[{"data": {"viewer": {"login": "gopher"}}}]`)
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}}).
+		WithMiddleware(func(next graphql.Handler) graphql.Handler {
+			return func(ctx context.Context, req *graphql.Request) (*graphql.Response, error) {
+				if req.OperationName == "Authenticated" {
+					req.Header.Set("Authorization", "Bearer token")
+				}
+				return next(ctx, req)
+			}
+		})
+
+	var q1, q2 struct {
+		Viewer struct {
+			Login graphql.String
+		}
+	}
+	errs, err := client.Batch(context.Background(), []graphql.BatchOperation{
+		{Type: graphql.QueryOperation, Name: "Authenticated", Value: &q1},
+		{Type: graphql.QueryOperation, Name: "Anonymous", Value: &q2},
+	})
+	if err == nil {
+		t.Fatal("got error: nil, want: non-nil")
+	}
+	if errs[0] != nil {
+		t.Errorf("got errs[0]: %v, want: nil", errs[0])
+	}
+	if got, want := q1.Viewer.Login, graphql.String("gopher"); got != want {
+		t.Errorf("got q1.Viewer.Login: %q, want: %q", got, want)
+	}
+	if errs[1] != err {
+		t.Errorf("got errs[1]: %v, want: %v", errs[1], err)
+	}
+}
+
+func TestClient_WithBatching(t *testing.T) {
+	var mu sync.Mutex
+	var batchSizes []int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		var in []struct {
+			Variables struct{ Login string }
+		}
+		if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		batchSizes = append(batchSizes, len(in))
+		mu.Unlock()
+		out := make([]interface{}, len(in))
+		for i, op := range in {
+			out[i] = map[string]interface{}{"data": map[string]interface{}{"user": map[string]interface{}{"name": op.Variables.Login}}}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(out); err != nil {
+			t.Error(err)
+		}
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}}).
+		WithBatching(time.Hour, 4)
+
+	logins := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	var wg sync.WaitGroup
+	for _, login := range logins {
+		wg.Add(1)
+		go func(login string) {
+			defer wg.Done()
+			var q struct {
+				User struct {
+					Name string
+				} `graphql:"user(login: $login)"`
+			}
+			err := client.Query(context.Background(), &q, map[string]interface{}{"login": graphql.String(login)})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if got, want := q.User.Name, login; got != want {
+				t.Errorf("got q.User.Name: %q, want: %q", got, want)
+			}
+		}(login)
+	}
+	wg.Wait()
+	if got, want := batchSizes, []int{4, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got batch sizes: %v, want: %v", got, want)
+	}
+}
+
+func TestClient_WithBatching_cancel(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		var in []json.RawMessage
+		if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+			t.Error(err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `[{"data": {"user": {"name": "Gopher"}}}, {"data": {"user": {"name": "Gopher"}}}]`)
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}}).
+		WithBatching(50*time.Millisecond, 0)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	var q1, q2 struct {
+		User struct {
+			Name graphql.String
+		}
+	}
+	go func() { errs <- client.Query(canceled, &q1, nil) }()
+	go func() { errs <- client.Query(context.Background(), &q2, nil) }()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	var gotCanceled int
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err == context.Canceled {
+			gotCanceled++
+		} else if err != nil {
+			t.Error(err)
+		}
+	}
+	if got, want := gotCanceled, 1; got != want {
+		t.Errorf("got %d canceled queries, want: %d", got, want)
+	}
+	if got, want := q2.User.Name, graphql.String("Gopher"); got != want {
+		t.Errorf("got q2.User.Name: %q, want: %q", got, want)
+	}
+}
+
+func TestClient_WithBatching_headers(t *testing.T) {
+	type userKey struct{}
+	var mu sync.Mutex
+	var batchSizes []int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		var in []struct {
+			Variables struct{ Login string }
+		}
+		if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		batchSizes = append(batchSizes, len(in))
+		mu.Unlock()
+		out := make([]interface{}, len(in))
+		for i, op := range in {
+			// Each query must be sent with the credentials of its caller.
+			if got, want := req.Header.Get("Authorization"), "Bearer "+op.Variables.Login; got != want {
+				t.Errorf("got Authorization: %q, want: %q", got, want)
+			}
+			out[i] = map[string]interface{}{"data": map[string]interface{}{"user": map[string]interface{}{"name": op.Variables.Login}}}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(out); err != nil {
+			t.Error(err)
+		}
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}}).
+		WithMiddleware(func(next graphql.Handler) graphql.Handler {
+			return func(ctx context.Context, req *graphql.Request) (*graphql.Response, error) {
+				req.Header.Set("Authorization", "Bearer "+ctx.Value(userKey{}).(string))
+				return next(ctx, req)
+			}
+		}).
+		WithBatching(time.Hour, 4)
+
+	var wg sync.WaitGroup
+	for _, login := range []string{"alice", "bob", "alice", "bob"} {
+		wg.Add(1)
+		go func(login string) {
+			defer wg.Done()
+			var q struct {
+				User struct {
+					Name string
+				} `graphql:"user(login: $login)"`
+			}
+			ctx := context.WithValue(context.Background(), userKey{}, login)
+			err := client.Query(ctx, &q, map[string]interface{}{"login": graphql.String(login)})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if got, want := q.User.Name, login; got != want {
+				t.Errorf("got q.User.Name: %q, want: %q", got, want)
+			}
+		}(login)
+	}
+	wg.Wait()
+	if got, want := batchSizes, []int{2, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got batch sizes: %v, want: %v", got, want)
+	}
+}
+
+// Test that the response of a batched query is buffered, rather than decoded
+// into the query struct by the goroutine flushing the batch, which may still
+// be running once the caller returned.
+func TestClient_WithBatching_single(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {"user": {"name": "Gopher"}}}`)
+	})
+	var data *json.RawMessage
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}}).
+		WithMiddleware(func(next graphql.Handler) graphql.Handler {
+			return func(ctx context.Context, req *graphql.Request) (*graphql.Response, error) {
+				resp, err := next(ctx, req)
+				if resp != nil {
+					data = resp.Data
+				}
+				return resp, err
+			}
+		}).
+		WithBatching(time.Millisecond, 0)
+
+	var q struct {
+		User struct {
+			Name graphql.String
+		}
+	}
+	if err := client.Query(context.Background(), &q, nil); err != nil {
+		t.Fatal(err)
+	}
+	if data == nil {
+		t.Error("got nil Response.Data, want: non-nil")
+	}
+	if got, want := q.User.Name, graphql.String("Gopher"); got != want {
+		t.Errorf("got q.User.Name: %q, want: %q", got, want)
+	}
+}
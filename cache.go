@@ -0,0 +1,673 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/InoiOy/go-graphql-client/ident"
+	"github.com/InoiOy/go-graphql-client/internal/jsonutil"
+)
+
+// CachePolicy determines how Client.Query uses the response cache.
+type CachePolicy uint8
+
+const (
+	// CacheFirst answers queries from the cache when every requested field
+	// is present, and sends them to the server otherwise.
+	CacheFirst CachePolicy = iota
+	// NetworkOnly always sends queries to the server, storing the response
+	// in the cache.
+	NetworkOnly
+	// CacheAndNetwork answers queries from the cache when possible, and
+	// also sends them to the server in the background to refresh the cache.
+	// The background request carries the values of the query's context, but
+	// isn't canceled with it; it times out after 30 seconds instead.
+	CacheAndNetwork
+)
+
+// Cache is an in-memory cache of GraphQL responses. Responses are normalized
+// into entities identified by their __typename and id fields, so that an entity
+// fetched by one operation is shared with, and updated by, all the others.
+// Objects without __typename and id are stored within their parent.
+//
+// A Cache is safe for concurrent use.
+type Cache struct {
+	mu       sync.Mutex
+	entities map[string]cacheRecord // Entity key -> fields.
+}
+
+// cacheRecord holds the fields of an object, keyed by field name and arguments.
+// Values are JSON values, cacheRefs, nested cacheRecords, or slices of those.
+type cacheRecord map[string]interface{}
+
+// cacheRef refers to a normalized entity.
+type cacheRef string
+
+// rootQueryKey is the key of the record that holds the root query fields.
+const rootQueryKey = "ROOT_QUERY"
+
+// NewCache creates an empty Cache.
+func NewCache() *Cache {
+	return &Cache{entities: make(map[string]cacheRecord)}
+}
+
+// Clear removes all entries from the cache.
+func (c *Cache) Clear() {
+	c.mu.Lock()
+	c.entities = make(map[string]cacheRecord)
+	c.mu.Unlock()
+}
+
+// WithCache enables the normalized response cache for queries. policy is
+// the default cache policy, which can be overridden per call with WithCachePolicy.
+// Entities returned by mutations are written to the cache as well.
+func (c *Client) WithCache(cache *Cache, policy CachePolicy) *Client {
+	c.cache = cache
+	c.cachePolicy = policy
+	return c
+}
+
+type cachePolicyKey struct{}
+
+// WithCachePolicy returns a copy of ctx that overrides the cache policy
+// of operations executed with it.
+func WithCachePolicy(ctx context.Context, policy CachePolicy) context.Context {
+	return context.WithValue(ctx, cachePolicyKey{}, policy)
+}
+
+// execCached executes req, derived from v, using the cache.
+func (c *Client) execCached(ctx context.Context, req *Request, v interface{}) (*Response, error) {
+	send := c.handler(c.send)
+	fields, ok := cacheFieldsOf(reflect.TypeOf(v))
+	if !ok {
+		// Operations with keyed fields aren't cached.
+		return send(ctx, req)
+	}
+	if req.Type != QueryOperation {
+		resp, err := send(ctx, req)
+		if err == nil && resp.Data != nil && len(resp.Errors) == 0 {
+			// Normalize entities, but don't store the root fields of the operation.
+			c.cache.write(make(cacheRecord), fields, req.Variables, *resp.Data)
+		}
+		return resp, err
+	}
+
+	policy := c.cachePolicy
+	if p, ok := ctx.Value(cachePolicyKey{}).(CachePolicy); ok {
+		policy = p
+	}
+	if policy != NetworkOnly {
+		if data, ok := c.cache.read(fields, req.Variables); ok {
+			if policy == CacheAndNetwork {
+				go c.refreshCache(ctx, req, fields)
+			}
+			return &Response{Data: &data}, nil
+		}
+	}
+	resp, err := send(ctx, req)
+	if err == nil && resp.Data != nil && len(resp.Errors) == 0 {
+		c.cache.write(nil, fields, req.Variables, *resp.Data)
+	}
+	return resp, err
+}
+
+// cacheRefreshTimeout bounds the time spent refreshing the cache in the background.
+const cacheRefreshTimeout = 30 * time.Second
+
+// refreshCache sends req to the server in the background, and stores the response.
+// The request carries the values of ctx, the context of the operation answered
+// from the cache, but isn't canceled with it.
+func (c *Client) refreshCache(ctx context.Context, req *Request, fields []*cacheField) {
+	ctx, cancel := context.WithTimeout(detachedContext{ctx}, cacheRefreshTimeout)
+	defer cancel()
+	resp, err := c.handler(c.send)(ctx, req)
+	if err == nil && resp.Data != nil && len(resp.Errors) == 0 {
+		c.cache.write(nil, fields, req.Variables, *resp.Data)
+	}
+}
+
+// detachedContext carries the values of its parent, without its deadline
+// or cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)           { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}                 { return nil }
+func (detachedContext) Err() error                            { return nil }
+func (ctx detachedContext) Value(key interface{}) interface{} { return ctx.parent.Value(key) }
+
+// cacheField describes a field of a query, as derived from its Go type.
+type cacheField struct {
+	responseKey string // Key of the field in the response: its alias or name.
+	name        string
+	args        string // Arguments, without parentheses. Empty if none.
+
+	// typeCondition is the type condition of an inline fragment.
+	// Fields of inline fragments and embedded structs are merged into
+	// their parent object; such fields have no responseKey.
+	typeCondition string
+
+	// directives of the field or inline fragment, e.g. "@include(if: $withEmail)".
+	directives string
+
+	children []*cacheField // Selection set. Nil for scalars.
+}
+
+// storageKey returns the key of f in a cache record, with variables
+// substituted into the arguments.
+func (f *cacheField) storageKey(variables map[string]interface{}) string {
+	if f.args == "" {
+		return f.name
+	}
+	return f.name + "(" + substituteVariables(f.args, variables) + ")"
+}
+
+// skipped reports whether f is left out of the response by a @skip or
+// @include directive, given variables.
+func (f *cacheField) skipped(variables map[string]interface{}) bool {
+	directives := f.directives
+	for directives != "" {
+		i := strings.Index(directives, "@")
+		if i == -1 {
+			break
+		}
+		directives = directives[i+1:]
+		j := 0
+		for j < len(directives) && isNameChar(directives[j]) {
+			j++
+		}
+		name, rest := directives[:j], strings.TrimSpace(directives[j:])
+		var args string
+		if strings.HasPrefix(rest, "(") {
+			if end := closingParen(rest, 0); end != -1 {
+				args = rest[1:end]
+			}
+		}
+		if name != "skip" && name != "include" {
+			continue
+		}
+		cond, ok := directiveCondition(args, variables)
+		if ok && cond == (name == "skip") {
+			return true
+		}
+	}
+	return false
+}
+
+// directiveCondition returns the value of the if argument of a @skip or
+// @include directive with arguments args, given variables, and whether it
+// could be determined.
+func directiveCondition(args string, variables map[string]interface{}) (bool, bool) {
+	args = strings.TrimSpace(args)
+	if !strings.HasPrefix(args, "if") {
+		return false, false
+	}
+	value := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(args[len("if"):]), ":"))
+	switch {
+	case value == "true":
+		return true, true
+	case value == "false":
+		return false, true
+	case strings.HasPrefix(value, "$"):
+		v, ok := variables[value[1:]]
+		if !ok {
+			return false, false
+		}
+		b := indirect(reflect.ValueOf(variableValue(v)))
+		if b.Kind() != reflect.Bool {
+			return false, false
+		}
+		return b.Bool(), true
+	default:
+		return false, false
+	}
+}
+
+// directivesOf returns the directives of a field or inline fragment as
+// written in a graphql struct tag, e.g. "@include(if: $withEmail)" for
+// `email @include(if: $withEmail)`.
+func directivesOf(s string) string {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			i = skipString(s, i) - 1
+		case '(':
+			if j := closingParen(s, i); j != -1 {
+				i = j
+			}
+		case '@':
+			return strings.TrimSpace(s[i:])
+		}
+	}
+	return ""
+}
+
+// fragmentKey returns the key of the marker recording whether the inline
+// fragment f applies to an object.
+func (f *cacheField) fragmentKey() string {
+	return "... on " + f.typeCondition
+}
+
+var cacheFields sync.Map // map[reflect.Type][]*cacheField
+
+// cacheFieldsOf returns the selection set of query type t, like writeQuery
+// writes it. It returns false if t has keyed fields, whose selection
+// depends on the keys of their map rather than on t.
+func cacheFieldsOf(t reflect.Type) ([]*cacheField, bool) {
+	if fields, ok := cacheFields.Load(t); ok {
+		return fields.([]*cacheField), fields.([]*cacheField) != nil
+	}
+	var fields []*cacheField
+	if !hasDynamicFields(t, make(map[reflect.Type]bool)) {
+		fields = selectionOf(t)
+	}
+	cacheFields.Store(t, fields)
+	return fields, fields != nil
+}
+
+// hasDynamicFields reports whether type t has keyed fields or interface
+// fields, whose selections depend on the values or the registered types.
+// seen holds the struct types already visited.
+func hasDynamicFields(t reflect.Type, seen map[reflect.Type]bool) bool {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Interface && t.NumMethod() > 0 {
+		return true
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return false
+	}
+	seen[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		if _, ok := jsonutil.KeyedField(t.Field(i)); ok || hasDynamicFields(t.Field(i).Type, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+func selectionOf(t reflect.Type) []*cacheField {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || reflect.PtrTo(t).Implements(jsonUnmarshaler) {
+		return nil
+	}
+	if _, typeCondition, ok := jsonutil.FragmentOf(t); ok {
+		// Named fragment, spread into the parent.
+		return []*cacheField{{typeCondition: typeCondition, children: fieldsOf(t)}}
+	}
+	return fieldsOf(t)
+}
+
+// fieldsOf returns the fields of struct t.
+func fieldsOf(t reflect.Type) []*cacheField {
+	fields := []*cacheField{}
+	if typeConditions, typename := jsonutil.SelectsTypename(t); typeConditions && !typename {
+		// Selected by writeQuery.
+		fields = append(fields, &cacheField{responseKey: "__typename", name: "__typename"})
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		value, ok := f.Tag.Lookup("graphql")
+		_, spread := jsonutil.NamedSpread(value)
+		switch {
+		case f.Anonymous && !ok:
+			// Embedded struct, inlined into the parent.
+			fields = append(fields, &cacheField{children: selectionOf(f.Type)})
+		case ok && spread:
+			// Spread of a named fragment.
+			fields = append(fields, &cacheField{directives: directivesOf(value), children: selectionOf(f.Type)})
+		case ok && strings.HasPrefix(strings.TrimSpace(value), "..."):
+			typeCondition := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(value), "..."))
+			if i := strings.Index(typeCondition, "@"); i != -1 {
+				// Directives, e.g. "... on User @defer".
+				typeCondition = typeCondition[:i]
+			}
+			typeCondition = strings.TrimSpace(strings.TrimPrefix(typeCondition, "on "))
+			fields = append(fields, &cacheField{typeCondition: typeCondition, directives: directivesOf(value), children: selectionOf(f.Type)})
+		default:
+			cf := &cacheField{directives: directivesOf(value), children: selectionOf(f.Type)}
+			if ok {
+				cf.responseKey, cf.name, cf.args = parseField(value)
+			} else {
+				cf.name = ident.ParseMixedCaps(f.Name).ToLowerCamelCase()
+				cf.responseKey = cf.name
+			}
+			fields = append(fields, cf)
+		}
+	}
+	return fields
+}
+
+// parseField parses a field as written in a graphql struct tag,
+// e.g. `alias: name(arg: $var)`.
+func parseField(s string) (responseKey, name, args string) {
+	s = strings.TrimSpace(s)
+	name = s
+	if i := strings.IndexAny(s, ":("); i != -1 && s[i] == ':' {
+		// Aliased field.
+		responseKey = strings.TrimSpace(s[:i])
+		name = strings.TrimSpace(s[i+1:])
+	}
+	if i := strings.IndexAny(name, "(@"); i != -1 && name[i] == '(' {
+		if j := closingParen(name, i); j != -1 {
+			args = strings.TrimSpace(name[i+1 : j])
+		}
+		name = strings.TrimSpace(name[:i])
+	}
+	if i := strings.IndexAny(name, " \t\n@"); i != -1 {
+		name = name[:i]
+	}
+	if responseKey == "" {
+		responseKey = name
+	}
+	return responseKey, name, args
+}
+
+// closingParen returns the index of the parenthesis closing the one
+// at index open of s, skipping string literals, or -1 if there is none.
+func closingParen(s string, open int) int {
+	depth := 0
+	inString := false
+	for i := open; i < len(s); i++ {
+		switch c := s[i]; {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// substituteVariables replaces references to variables in args
+// with their JSON encoded values.
+func substituteVariables(args string, variables map[string]interface{}) string {
+	if !strings.Contains(args, "$") {
+		return args
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(args); i++ {
+		if args[i] != '$' {
+			buf.WriteByte(args[i])
+			continue
+		}
+		j := i + 1
+		for j < len(args) && isNameChar(args[j]) {
+			j++
+		}
+		b, err := json.Marshal(variables[args[i+1:j]])
+		if err != nil {
+			b = []byte(args[i:j])
+		}
+		buf.Write(b)
+		i = j - 1
+	}
+	return buf.String()
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// write normalizes the response data of an operation with the given fields
+// into the cache. The root fields are stored into root, or into the root query
+// record if root is nil.
+func (c *Cache) write(root cacheRecord, fields []*cacheField, variables map[string]interface{}, data json.RawMessage) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var obj map[string]interface{}
+	if err := dec.Decode(&obj); err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if root == nil {
+		root = c.entities[rootQueryKey]
+		if root == nil {
+			root = make(cacheRecord)
+			c.entities[rootQueryKey] = root
+		}
+	}
+	c.writeObject(root, fields, variables, obj)
+}
+
+func (c *Cache) writeObject(record cacheRecord, fields []*cacheField, variables map[string]interface{}, obj map[string]interface{}) {
+	for _, f := range fields {
+		if f.skipped(variables) {
+			continue
+		}
+		if f.responseKey == "" {
+			if f.typeCondition != "" {
+				applies := true
+				if typename, ok := obj["__typename"].(string); ok {
+					applies = jsonutil.FragmentApplies(f.typeCondition, typename)
+				} else {
+					// Assume the fragment applies if its fields are present.
+					for _, child := range f.children {
+						if child.responseKey == "" || child.skipped(variables) {
+							continue
+						}
+						if _, ok := obj[child.responseKey]; !ok {
+							applies = false
+							break
+						}
+					}
+				}
+				record[f.fragmentKey()] = applies
+				if !applies {
+					continue
+				}
+			}
+			c.writeObject(record, f.children, variables, obj)
+			continue
+		}
+		value, ok := obj[f.responseKey]
+		if !ok {
+			continue
+		}
+		key := f.storageKey(variables)
+		record[key] = c.normalize(record[key], f, variables, value)
+	}
+}
+
+// normalize converts a response value of field f into its cached form.
+// existing is the currently cached value, if any.
+func (c *Cache) normalize(existing interface{}, f *cacheField, variables map[string]interface{}, value interface{}) interface{} {
+	if f.children == nil {
+		// Scalar.
+		return value
+	}
+	switch value := value.(type) {
+	case []interface{}:
+		list := make([]interface{}, len(value))
+		for i, v := range value {
+			list[i] = c.normalize(nil, f, variables, v)
+		}
+		return list
+	case map[string]interface{}:
+		if key := entityKey(value); key != "" {
+			record := c.entities[key]
+			if record == nil {
+				record = make(cacheRecord)
+				c.entities[key] = record
+			}
+			c.writeObject(record, f.children, variables, value)
+			return cacheRef(key)
+		}
+		record, ok := existing.(cacheRecord)
+		if !ok {
+			record = make(cacheRecord)
+		}
+		c.writeObject(record, f.children, variables, value)
+		return record
+	default:
+		return value
+	}
+}
+
+// entityKey returns the key identifying the entity obj,
+// or "" if it doesn't have both __typename and id.
+func entityKey(obj map[string]interface{}) string {
+	typename, ok := obj["__typename"].(string)
+	if !ok {
+		return ""
+	}
+	switch id := obj["id"].(type) {
+	case string:
+		return typename + ":" + id
+	case json.Number:
+		return typename + ":" + id.String()
+	default:
+		return ""
+	}
+}
+
+// read returns the response data of an operation with the given fields,
+// and whether every requested field is present in the cache.
+func (c *Cache) read(fields []*cacheField, variables map[string]interface{}) (json.RawMessage, bool) {
+	c.mu.Lock()
+	root := c.entities[rootQueryKey]
+	if root == nil {
+		c.mu.Unlock()
+		return nil, false
+	}
+	obj, ok := c.readObject(root, fields, variables, nil)
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func (c *Cache) readObject(record cacheRecord, fields []*cacheField, variables map[string]interface{}, obj orderedObject) (orderedObject, bool) {
+	for _, f := range fields {
+		if f.skipped(variables) {
+			continue
+		}
+		if f.responseKey == "" {
+			if f.typeCondition != "" {
+				applies, ok := record[f.fragmentKey()].(bool)
+				if !ok {
+					return nil, false
+				}
+				if !applies {
+					continue
+				}
+			}
+			var ok bool
+			obj, ok = c.readObject(record, f.children, variables, obj)
+			if !ok {
+				return nil, false
+			}
+			continue
+		}
+		cached, ok := record[f.storageKey(variables)]
+		if !ok {
+			return nil, false
+		}
+		value, ok := c.denormalize(f, variables, cached)
+		if !ok {
+			return nil, false
+		}
+		obj = obj.set(f.responseKey, value)
+	}
+	if obj == nil {
+		obj = orderedObject{}
+	}
+	return obj, true
+}
+
+// denormalize converts a cached value of field f into its response form.
+func (c *Cache) denormalize(f *cacheField, variables map[string]interface{}, cached interface{}) (interface{}, bool) {
+	switch cached := cached.(type) {
+	case []interface{}:
+		list := make([]interface{}, len(cached))
+		for i, v := range cached {
+			var ok bool
+			list[i], ok = c.denormalize(f, variables, v)
+			if !ok {
+				return nil, false
+			}
+		}
+		return list, true
+	case cacheRef:
+		record, ok := c.entities[string(cached)]
+		if !ok {
+			return nil, false
+		}
+		return c.readObject(record, f.children, variables, nil)
+	case cacheRecord:
+		return c.readObject(cached, f.children, variables, nil)
+	default:
+		if f.children != nil && cached != nil {
+			return nil, false
+		}
+		return cached, true
+	}
+}
+
+// orderedObject is a JSON object that preserves the order of its keys.
+type orderedObject []orderedField
+
+type orderedField struct {
+	key   string
+	value interface{}
+}
+
+// set sets the value of key, appending it if not yet present.
+func (o orderedObject) set(key string, value interface{}) orderedObject {
+	for i := range o {
+		if o[i].key == key {
+			o[i].value = value
+			return o
+		}
+	}
+	return append(o, orderedField{key: key, value: value})
+}
+
+// MarshalJSON implements json.Marshaler.
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range o {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(f.key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %v", f.key, err)
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
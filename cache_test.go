@@ -0,0 +1,410 @@
+package graphql_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/InoiOy/go-graphql-client"
+)
+
+// cacheServer is a stand-in GraphQL server for cache tests.
+// It responds to requests with the response registered for their query.
+type cacheServer struct {
+	t         *testing.T
+	responses map[string]string // Query -> response.
+	requests  []string
+}
+
+func (s *cacheServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var in struct{ Query string }
+	if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+		s.t.Error(err)
+	}
+	s.requests = append(s.requests, in.Query)
+	resp, ok := s.responses[in.Query]
+	if !ok {
+		s.t.Errorf("unexpected query: %s", in.Query)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	mustWrite(w, resp)
+}
+
+type cachedUser struct {
+	Typename string `graphql:"__typename"`
+	ID       graphql.ID
+	Name     graphql.String
+}
+
+func TestClient_WithCache(t *testing.T) {
+	s := &cacheServer{t: t, responses: map[string]string{
+		`query ($id:ID!){user(id: $id){__typename,id,name}}`:       `{"data": {"user": {"__typename": "User", "id": "1", "name": "Gopher"}}}`,
+		`query ($id:ID!){user(id: $id){__typename,id,name,email}}`: `{"data": {"user": {"__typename": "User", "id": "1", "name": "Gopher", "email": "gopher@example.com"}}}`,
+		`mutation ($id:ID!){rename(id: $id){__typename,id,name}}`:  `{"data": {"rename": {"__typename": "User", "id": "1", "name": "Renamed"}}}`,
+	}}
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: s}}).
+		WithCache(graphql.NewCache(), graphql.CacheFirst)
+	variables := map[string]interface{}{"id": graphql.Variable{Value: "1", Type: "ID!"}}
+
+	var q struct {
+		User cachedUser `graphql:"user(id: $id)"`
+	}
+	for i := 0; i < 2; i++ {
+		if err := client.Query(context.Background(), &q, variables); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := q.User.Name, graphql.String("Gopher"); got != want {
+			t.Errorf("got q.User.Name: %q, want: %q", got, want)
+		}
+	}
+	if got, want := len(s.requests), 1; got != want {
+		t.Errorf("got %d requests, want: %d", got, want)
+	}
+
+	// A query requesting a field missing from the cache is sent to the server.
+	var withEmail struct {
+		User struct {
+			cachedUser
+			Email graphql.String
+		} `graphql:"user(id: $id)"`
+	}
+	if err := client.Query(context.Background(), &withEmail, variables); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := withEmail.User.Email, graphql.String("gopher@example.com"); got != want {
+		t.Errorf("got withEmail.User.Email: %q, want: %q", got, want)
+	}
+	if got, want := len(s.requests), 2; got != want {
+		t.Errorf("got %d requests, want: %d", got, want)
+	}
+
+	// Entities returned by mutations update the cache.
+	var m struct {
+		Rename cachedUser `graphql:"rename(id: $id)"`
+	}
+	if err := client.Mutate(context.Background(), &m, variables); err != nil {
+		t.Fatal(err)
+	}
+	q.User = cachedUser{}
+	if err := client.Query(context.Background(), &q, variables); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := q.User.Name, graphql.String("Renamed"); got != want {
+		t.Errorf("got q.User.Name: %q, want: %q", got, want)
+	}
+	if got, want := len(s.requests), 3; got != want {
+		t.Errorf("got %d requests, want: %d", got, want)
+	}
+
+	// NetworkOnly bypasses the cache.
+	if err := client.Query(graphql.WithCachePolicy(context.Background(), graphql.NetworkOnly), &q, variables); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := q.User.Name, graphql.String("Gopher"); got != want {
+		t.Errorf("got q.User.Name: %q, want: %q", got, want)
+	}
+	if got, want := len(s.requests), 4; got != want {
+		t.Errorf("got %d requests, want: %d", got, want)
+	}
+}
+
+func TestClient_WithCache_cacheAndNetwork(t *testing.T) {
+	refreshed := make(chan struct{}, 1)
+	name := "Gopher"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {"viewer": {"__typename": "User", "id": "1", "name": "`+name+`"}}}`)
+		refreshed <- struct{}{}
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}}).
+		WithCache(graphql.NewCache(), graphql.CacheAndNetwork)
+
+	var q struct {
+		Viewer cachedUser
+	}
+	if err := client.Query(context.Background(), &q, nil); err != nil {
+		t.Fatal(err)
+	}
+	<-refreshed
+	name = "Renamed"
+
+	// Answered from the cache, and refreshed in the background.
+	if err := client.Query(context.Background(), &q, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := q.Viewer.Name, graphql.String("Gopher"); got != want {
+		t.Errorf("got q.Viewer.Name: %q, want: %q", got, want)
+	}
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("cache wasn't refreshed")
+	}
+	// The response is written to the cache shortly after the server responded.
+	for deadline := time.Now().Add(time.Second); ; {
+		if err := client.Query(context.Background(), &q, nil); err != nil {
+			t.Fatal(err)
+		}
+		<-refreshed
+		if q.Viewer.Name == "Renamed" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got q.Viewer.Name: %q, want: %q", q.Viewer.Name, "Renamed")
+		}
+	}
+}
+
+// Test that the background refresh carries the values of the query's context,
+// and isn't canceled with it.
+func TestClient_WithCache_cacheAndNetworkContext(t *testing.T) {
+	type userKey struct{}
+	refreshed := make(chan string, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {"viewer": {"__typename": "User", "id": "1", "name": "Gopher"}}}`)
+		refreshed <- req.Header.Get("Authorization")
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}}).
+		WithMiddleware(func(next graphql.Handler) graphql.Handler {
+			return func(ctx context.Context, req *graphql.Request) (*graphql.Response, error) {
+				if _, ok := ctx.Deadline(); !ok {
+					t.Error("got no deadline, want one")
+				}
+				req.Header.Set("Authorization", "Bearer "+ctx.Value(userKey{}).(string))
+				return next(ctx, req)
+			}
+		}).
+		WithCache(graphql.NewCache(), graphql.CacheAndNetwork)
+
+	var q struct {
+		Viewer cachedUser
+	}
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), userKey{}, "alice"), time.Second)
+	if err := client.Query(ctx, &q, nil); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	<-refreshed
+
+	ctx, cancel = context.WithTimeout(context.WithValue(context.Background(), userKey{}, "bob"), time.Second)
+	err := client.Query(ctx, &q, nil)
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-refreshed:
+		if want := "Bearer bob"; got != want {
+			t.Errorf("got Authorization: %q, want: %q", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cache wasn't refreshed")
+	}
+}
+
+func TestClient_WithCache_fragments(t *testing.T) {
+	var requests int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {"search": [
+			{"__typename": "User", "id": "1", "login": "gopher"},
+			{"__typename": "Repository", "id": "2", "nameWithOwner": "gopher/go"}
+		]}}`)
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}}).
+		WithCache(graphql.NewCache(), graphql.CacheFirst)
+
+	var q struct {
+		Search []struct {
+			Typename string `graphql:"__typename"`
+			ID       graphql.ID
+			User     struct {
+				Login graphql.String
+			} `graphql:"... on User"`
+			Repository struct {
+				NameWithOwner graphql.String
+			} `graphql:"... on Repository"`
+		} `graphql:"search(query: \"go\")"`
+	}
+	for i := 0; i < 2; i++ {
+		if err := client.Query(context.Background(), &q, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := q.Search[0].User.Login, graphql.String("gopher"); got != want {
+			t.Errorf("got q.Search[0].User.Login: %q, want: %q", got, want)
+		}
+		if got, want := q.Search[1].Repository.NameWithOwner, graphql.String("gopher/go"); got != want {
+			t.Errorf("got q.Search[1].Repository.NameWithOwner: %q, want: %q", got, want)
+		}
+	}
+	if got, want := requests, 1; got != want {
+		t.Errorf("got %d requests, want: %d", got, want)
+	}
+}
+
+func TestClient_WithCache_directives(t *testing.T) {
+	const document = `query ($id:ID!$withEmail:Boolean!){user(id: $id){__typename,id,name,email @include(if: $withEmail),... on Admin @skip(if: $withEmail){role}}}`
+	var requests []map[string]interface{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		var in struct {
+			Query     string
+			Variables map[string]interface{}
+		}
+		if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+			t.Fatal(err)
+		}
+		if in.Query != document {
+			t.Errorf("got query: %s, want: %s", in.Query, document)
+		}
+		requests = append(requests, in.Variables)
+		w.Header().Set("Content-Type", "application/json")
+		if in.Variables["withEmail"] == true {
+			mustWrite(w, `{"data": {"user": {"__typename": "Admin", "id": "1", "name": "Gopher", "email": "gopher@example.com"}}}`)
+		} else {
+			mustWrite(w, `{"data": {"user": {"__typename": "Admin", "id": "1", "name": "Gopher", "role": "owner"}}}`)
+		}
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}}).
+		WithCache(graphql.NewCache(), graphql.CacheFirst)
+
+	type query struct {
+		User struct {
+			cachedUser
+			Email *graphql.String `graphql:"email @include(if: $withEmail)"`
+			Admin struct {
+				Role graphql.String
+			} `graphql:"... on Admin @skip(if: $withEmail)"`
+		} `graphql:"user(id: $id)"`
+	}
+	for i := 0; i < 2; i++ {
+		// The email and role fields are skipped in turn, and the
+		// responses missing them are answered from the cache.
+		for _, withEmail := range []bool{false, true} {
+			var q query
+			err := client.Query(context.Background(), &q, map[string]interface{}{
+				"id":        graphql.Variable{Value: "1", Type: "ID!"},
+				"withEmail": graphql.Boolean(withEmail),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := q.User.Email != nil, withEmail; got != want {
+				t.Errorf("withEmail %v: got email: %v, want: %v", withEmail, got, want)
+			}
+			if got, want := q.User.Admin.Role != "", !withEmail; got != want {
+				t.Errorf("withEmail %v: got role: %v, want: %v", withEmail, got, want)
+			}
+		}
+	}
+	if got, want := len(requests), 2; got != want {
+		t.Errorf("got %d requests, want: %d", got, want)
+	}
+}
+
+type cachedUserFields struct {
+	Typename string `graphql:"__typename"`
+	ID       graphql.ID
+	Login    graphql.String
+}
+
+func (cachedUserFields) GetGraphQLFragment() (string, string) { return "UserFields", "User" }
+
+func TestClient_WithCache_namedFragments(t *testing.T) {
+	const document = `{viewer{...UserFields},search(query: "go"){__typename,...UserFields,... on Repository{nameWithOwner}}}fragment UserFields on User{__typename,id,login}`
+	var requests int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		var in struct{ Query string }
+		if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+			t.Fatal(err)
+		}
+		if in.Query != document {
+			t.Errorf("got query: %s, want: %s", in.Query, document)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {
+			"viewer": {"__typename": "User", "id": "1", "login": "gopher"},
+			"search": [
+				{"__typename": "User", "id": "1", "login": "gopher"},
+				{"__typename": "Repository", "nameWithOwner": "gopher/go"}
+			]
+		}}`)
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}}).
+		WithCache(graphql.NewCache(), graphql.CacheFirst)
+
+	var q struct {
+		Viewer cachedUserFields
+		Search []struct {
+			cachedUserFields
+			Repository struct {
+				NameWithOwner graphql.String
+			} `graphql:"... on Repository"`
+		} `graphql:"search(query: \"go\")"`
+	}
+	for i := 0; i < 2; i++ {
+		if err := client.Query(context.Background(), &q, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := q.Viewer.Login, graphql.String("gopher"); got != want {
+			t.Errorf("got q.Viewer.Login: %q, want: %q", got, want)
+		}
+		if got, want := q.Search[0].Login, graphql.String("gopher"); got != want {
+			t.Errorf("got q.Search[0].Login: %q, want: %q", got, want)
+		}
+		if got, want := q.Search[1].Repository.NameWithOwner, graphql.String("gopher/go"); got != want {
+			t.Errorf("got q.Search[1].Repository.NameWithOwner: %q, want: %q", got, want)
+		}
+	}
+	if got, want := requests, 1; got != want {
+		t.Errorf("got %d requests, want: %d", got, want)
+	}
+}
+
+// Test that hand-written documents bypass the cache, since the fields of
+// the query struct don't describe their arguments.
+func TestClient_WithCache_execString(t *testing.T) {
+	const document = `query($login:String!){user(login:$login){name}}`
+	var requests int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		var in struct {
+			Variables struct{ Login string }
+		}
+		if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {"user": {"name": "`+in.Variables.Login+`"}}}`)
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}}).
+		WithCache(graphql.NewCache(), graphql.CacheFirst)
+
+	for _, login := range []string{"alice", "bob", "alice"} {
+		var q struct {
+			User struct {
+				Name string
+			}
+		}
+		err := client.ExecString(context.Background(), document, &q, map[string]interface{}{"login": login})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := q.User.Name, login; got != want {
+			t.Errorf("got q.User.Name: %q, want: %q", got, want)
+		}
+	}
+	if got, want := requests, 3; got != want {
+		t.Errorf("got %d requests, want: %d", got, want)
+	}
+}
@@ -0,0 +1,108 @@
+package graphql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// documentOperation returns the type of the operation named name in
+// GraphQL document, or of its only operation if name is empty.
+func documentOperation(document, name string) (OperationType, error) {
+	type operation struct {
+		typ  string
+		name string
+	}
+	var operations []operation
+	depth := 0
+	inHeader := false   // Between an operation type and its selection set.
+	expectName := false // Previous token was an operation type at top level.
+	for i := 0; i < len(document); {
+		c := document[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+			continue
+		case c == '#':
+			for i < len(document) && document[i] != '\n' {
+				i++
+			}
+			continue
+		case c == '"':
+			i = skipString(document, i)
+			expectName = false
+			continue
+		case c == '{':
+			if depth == 0 && !inHeader {
+				// Query shorthand, e.g. "{viewer{login}}".
+				operations = append(operations, operation{typ: "query"})
+			}
+			if depth == 0 {
+				inHeader = false
+			}
+			depth++
+		case c == '}':
+			depth--
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case isNameChar(c):
+			j := i
+			for j < len(document) && isNameChar(document[j]) {
+				j++
+			}
+			word := document[i:j]
+			i = j
+			switch {
+			case depth == 0 && expectName:
+				operations[len(operations)-1].name = word
+				expectName = false
+			case depth == 0 && (word == "query" || word == "mutation" || word == "subscription" || word == "fragment"):
+				operations = append(operations, operation{typ: word})
+				inHeader, expectName = true, true
+			}
+			continue
+		}
+		expectName = false
+		i++
+	}
+
+	var found []operation
+	for _, op := range operations {
+		if op.typ != "fragment" && (name == "" || op.name == name) {
+			found = append(found, op)
+		}
+	}
+	switch {
+	case len(found) == 0 && name != "":
+		return 0, fmt.Errorf("operation %q not found in document", name)
+	case len(found) == 0:
+		return 0, fmt.Errorf("no operation found in document")
+	case len(found) > 1 && name == "":
+		return 0, fmt.Errorf("document has %d operations, an operation name is required", len(found))
+	}
+	switch found[0].typ {
+	case "query":
+		return QueryOperation, nil
+	case "mutation":
+		return MutationOperation, nil
+	default:
+		return SubscriptionOperation, nil
+	}
+}
+
+// skipString returns the index after the string literal starting at index i of s.
+func skipString(s string, i int) int {
+	if strings.HasPrefix(s[i:], `"""`) {
+		if j := strings.Index(s[i+3:], `"""`); j != -1 {
+			return i + 3 + j + 3
+		}
+		return len(s)
+	}
+	for i++; i < len(s) && s[i] != '"'; i++ {
+		if s[i] == '\\' {
+			i++
+		}
+	}
+	return i + 1
+}
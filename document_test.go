@@ -0,0 +1,49 @@
+package graphql
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDocumentOperation(t *testing.T) {
+	const document = `
+		# A "query" in a comment.
+		fragment UserFields on User { name }
+		query GetUser($login: String! = "mutation {") { user(login: $login) { ...UserFields } }
+		mutation Rename { rename(name: "query") { ...UserFields } }
+		subscription OnRename { renamed { ...UserFields } }
+	`
+	tests := []struct {
+		document string
+		name     string
+		want     OperationType
+		wantErr  string
+	}{
+		{document: `{viewer{login}}`, want: QueryOperation},
+		{document: `fragment F on User { id } { viewer { ...F } }`, want: QueryOperation},
+		{document: `query { viewer { login } }`, want: QueryOperation},
+		{document: `mutation($input:AddReactionInput!){addReaction(input:$input){subject{id}}}`, want: MutationOperation},
+		{document: document, name: "GetUser", want: QueryOperation},
+		{document: document, name: "Rename", want: MutationOperation},
+		{document: document, name: "OnRename", want: SubscriptionOperation},
+		{document: document, wantErr: "document has 3 operations, an operation name is required"},
+		{document: document, name: "Missing", wantErr: `operation "Missing" not found in document`},
+		{document: `fragment F on User { id }`, wantErr: "no operation found in document"},
+	}
+	for i, tc := range tests {
+		got, err := documentOperation(tc.document, tc.name)
+		if tc.wantErr != "" {
+			if gotErr := fmt.Sprint(err); gotErr != tc.wantErr {
+				t.Errorf("test case %d: got error: %v, want: %v", i, gotErr, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test case %d: %v", i, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("test case %d: got %v, want: %v", i, got, tc.want)
+		}
+	}
+}
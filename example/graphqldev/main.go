@@ -71,7 +71,7 @@ func run() error {
 		} `graphql:"character(id: $characterID)"`
 	}
 	variables := map[string]interface{}{
-		"characterID": graphql.ID("1003"),
+		"characterID": graphql.Variable{Value: "1003", Type: "ID!"},
 	}
 	err = client.Query(context.Background(), &q, variables)
 	if err != nil {
@@ -5,8 +5,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/InoiOy/go-graphql-client/internal/jsonutil"
 	"golang.org/x/net/context/ctxhttp"
@@ -14,8 +18,19 @@ import (
 
 // Client is a GraphQL client.
 type Client struct {
-	url        string // GraphQL server URL.
-	httpClient *http.Client
+	url         string // GraphQL server URL.
+	httpClient  *http.Client
+	middlewares []Middleware
+
+	persistedQueries *persistedQueries // Automatic persisted queries state, if enabled.
+	batcher          *batcher          // Automatic batching state, if enabled.
+	getQueries       bool              // Send queries as GET requests.
+	maxURLLength     int               // Maximum length of GET request URLs. Zero means no limit.
+	retryPolicy      *RetryPolicy      // Retry policy, if enabled.
+	cache            *Cache            // Normalized response cache, if enabled.
+	cachePolicy      CachePolicy       // Default cache policy.
+	tracer           Tracer            // Tracer of operations, if any.
+	types            typeNames         // GraphQL type names of Go types used as variables.
 }
 
 // NewClient creates a GraphQL client targeting the specified GraphQL server URL.
@@ -30,182 +45,476 @@ func NewClient(url string, httpClient *http.Client) *Client {
 	}
 }
 
+// WithGETQueries causes query operations to be sent as GET requests, with
+// the query, variables, operation name and extensions URL encoded, as described
+// by the GraphQL over HTTP specification. Mutations are always sent as POST.
+//
+// If the URL of a GET request would be longer than maxURLLength,
+// the query is sent as POST instead. Zero or negative maxURLLength
+// means no limit.
+func (c *Client) WithGETQueries(maxURLLength int) *Client {
+	c.getQueries = true
+	c.maxURLLength = maxURLLength
+	return c
+}
+
+// WithTypeName declares name as the GraphQL type name of the Go type of v,
+// when a value of that type is used as variable, e.g.
+//
+//	client.WithTypeName(uuid.UUID{}, "UUID")
+//
+// It takes precedence over the GraphQLType interface and the default
+// type names of native Go types: string, bool, integers, floats and time.Time
+// are String, Boolean, Int, Float and DateTime. Other named types use their
+// Go type name, as the scalar types of this package do.
+func (c *Client) WithTypeName(v interface{}, name string) *Client {
+	c.types = c.types.with(v, name)
+	return c
+}
+
 // Query executes a single GraphQL query request,
 // with a query derived from q, populating the response into it.
 // q should be a pointer to struct that corresponds to the GraphQL schema.
-func (c *Client) Query(ctx context.Context, q interface{}, variables map[string]interface{}) error {
-	return c.do(ctx, queryOperation, q, variables, "")
+func (c *Client) Query(ctx context.Context, q interface{}, variables interface{}) error {
+	return c.do(ctx, QueryOperation, q, variables, "")
 }
 
 // NamedQuery executes a single GraphQL query request, with operation name
-func (c *Client) NamedQuery(ctx context.Context, name string, q interface{}, variables map[string]interface{}) error {
-	return c.do(ctx, queryOperation, q, variables, name)
+func (c *Client) NamedQuery(ctx context.Context, name string, q interface{}, variables interface{}) error {
+	return c.do(ctx, QueryOperation, q, variables, name)
 }
 
 // Mutate executes a single GraphQL mutation request,
 // with a mutation derived from m, populating the response into it.
 // m should be a pointer to struct that corresponds to the GraphQL schema.
-func (c *Client) Mutate(ctx context.Context, m interface{}, variables map[string]interface{}) error {
-	return c.do(ctx, mutationOperation, m, variables, "")
+func (c *Client) Mutate(ctx context.Context, m interface{}, variables interface{}) error {
+	return c.do(ctx, MutationOperation, m, variables, "")
 }
 
 // NamedMutate executes a single GraphQL mutation request, with operation name
-func (c *Client) NamedMutate(ctx context.Context, name string, m interface{}, variables map[string]interface{}) error {
-	return c.do(ctx, mutationOperation, m, variables, name)
+func (c *Client) NamedMutate(ctx context.Context, name string, m interface{}, variables interface{}) error {
+	return c.do(ctx, MutationOperation, m, variables, name)
 }
 
 // Query executes a single GraphQL query request,
 // with a query derived from q, populating the response into it.
 // q should be a pointer to struct that corresponds to the GraphQL schema.
 // return raw bytes message.
-func (c *Client) QueryRaw(ctx context.Context, q interface{}, variables map[string]interface{}) (*json.RawMessage, error) {
-	return c.doRaw(ctx, queryOperation, q, variables, "")
+func (c *Client) QueryRaw(ctx context.Context, q interface{}, variables interface{}) (*json.RawMessage, error) {
+	return c.doRaw(ctx, QueryOperation, q, variables, "")
 }
 
 // NamedQueryRaw executes a single GraphQL query request, with operation name
 // return raw bytes message.
-func (c *Client) NamedQueryRaw(ctx context.Context, name string, q interface{}, variables map[string]interface{}) (*json.RawMessage, error) {
-	return c.doRaw(ctx, queryOperation, q, variables, name)
+func (c *Client) NamedQueryRaw(ctx context.Context, name string, q interface{}, variables interface{}) (*json.RawMessage, error) {
+	return c.doRaw(ctx, QueryOperation, q, variables, name)
 }
 
 // MutateRaw executes a single GraphQL mutation request,
 // with a mutation derived from m, populating the response into it.
 // m should be a pointer to struct that corresponds to the GraphQL schema.
 // return raw bytes message.
-func (c *Client) MutateRaw(ctx context.Context, m interface{}, variables map[string]interface{}) (*json.RawMessage, error) {
-	return c.doRaw(ctx, mutationOperation, m, variables, "")
+func (c *Client) MutateRaw(ctx context.Context, m interface{}, variables interface{}) (*json.RawMessage, error) {
+	return c.doRaw(ctx, MutationOperation, m, variables, "")
 }
 
 // NamedMutateRaw executes a single GraphQL mutation request, with operation name
 // return raw bytes message.
-func (c *Client) NamedMutateRaw(ctx context.Context, name string, m interface{}, variables map[string]interface{}) (*json.RawMessage, error) {
-	return c.doRaw(ctx, mutationOperation, m, variables, name)
+func (c *Client) NamedMutateRaw(ctx context.Context, name string, m interface{}, variables interface{}) (*json.RawMessage, error) {
+	return c.doRaw(ctx, MutationOperation, m, variables, name)
 }
 
-// do executes a single GraphQL operation.
-// return raw message and error
-func (c *Client) doRaw(ctx context.Context, op operationType, v interface{}, variables map[string]interface{}, name string) (*json.RawMessage, error) {
-	var query string
-	switch op {
-	case queryOperation:
-		query = constructQuery(v, variables, name)
-	case mutationOperation:
-		query = constructMutation(v, variables, name)
-	}
-	in := struct {
-		Query     string                 `json:"query"`
-		Variables map[string]interface{} `json:"variables,omitempty"`
-	}{
-		Query:     query,
-		Variables: variables,
-	}
-	var buf bytes.Buffer
-	err := json.NewEncoder(&buf).Encode(in)
+// Exec executes a single GraphQL operation of type op, derived from v,
+// and returns the complete response envelope: data, errors, extensions
+// and HTTP metadata. If the response has data, it's also populated into v.
+// If the response contains GraphQL errors, they are returned as error
+// alongside the response.
+func (c *Client) Exec(ctx context.Context, op OperationType, v interface{}, variables interface{}) (*Response, error) {
+	return c.doResponse(ctx, op, v, variables, "")
+}
+
+// NamedExec executes a single GraphQL operation of type op, with operation name,
+// and returns the complete response envelope.
+func (c *Client) NamedExec(ctx context.Context, op OperationType, name string, v interface{}, variables interface{}) (*Response, error) {
+	return c.doResponse(ctx, op, v, variables, name)
+}
+
+// ExecString executes the GraphQL document query, hand-written or returned
+// by ConstructQuery, with variables, and populates the response data into v.
+// v should be a pointer to struct matching the selection set of the operation,
+// or a pointer to map[string]interface{} (or any other type supported by
+// encoding/json) to decode the data as plain JSON. The operation type is
+// derived from the document, which must contain a single operation.
+// The response cache, if any, isn't used.
+func (c *Client) ExecString(ctx context.Context, query string, v interface{}, variables interface{}) error {
+	return c.NamedExecString(ctx, query, "", v, variables)
+}
+
+// NamedExecString executes the operation named operationName of the GraphQL
+// document query, which may contain several operations and fragments,
+// with variables, and populates the response data into v. See ExecString.
+func (c *Client) NamedExecString(ctx context.Context, query, operationName string, v interface{}, variables interface{}) error {
+	op, err := documentOperation(query, operationName)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	resp, err := ctxhttp.Post(ctx, c.httpClient, c.url, "application/json", &buf)
+	vars, err := variablesMap(variables)
 	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return nil, fmt.Errorf("non-200 OK status code: %v body: %q", resp.Status, body)
+		return err
 	}
-	var out struct {
-		Data   *json.RawMessage
-		Errors errors
-		//Extensions interface{} // Unused.
+	if op == SubscriptionOperation {
+		return fmt.Errorf("subscription operations must be executed with SubscriptionClient")
 	}
-	err = json.NewDecoder(resp.Body).Decode(&out)
+	// The response cache identifies fields by those of the query struct,
+	// which don't describe a hand-written document, so it's bypassed.
+	return c.runUncached(ctx, &Request{
+		Query:         query,
+		Variables:     payloadVariables(vars),
+		OperationName: operationName,
+		Type:          op,
+		Header:        make(http.Header),
+	}, v)
+}
+
+// Response is the complete response to a single GraphQL operation.
+type Response struct {
+	// Data is the raw response data. It's nil when the data was decoded
+	// directly into the query struct while being read, as Query, Mutate
+	// and their named variants do when the client has no middlewares,
+	// unless the operation is batched or may be retried.
+	Data       *json.RawMessage
+	Errors     Errors
+	Extensions map[string]interface{}
+
+	StatusCode int         // HTTP status code.
+	Header     http.Header // HTTP response headers.
+}
+
+// Request is a single GraphQL operation to be sent to the server.
+type Request struct {
+	Query         string                 `json:"query,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Extensions    map[string]interface{} `json:"extensions,omitempty"`
+
+	Type   OperationType `json:"-"` // Type of the operation. Not sent to the server.
+	Header http.Header   `json:"-"` // Additional HTTP request headers.
+
+	// target, if not nil, is where the response data is decoded into
+	// while it's being read, instead of being buffered in Response.Data.
+	// It's only used when the response is read by the goroutine of the
+	// caller, and is the final one.
+	target interface{}
+
+	// persisted, if not nil, is the cached document and hash of Query
+	// for automatic persisted queries.
+	persisted *persistedDocument
+}
+
+// exec executes a single GraphQL operation through the middleware chain.
+// A non-nil response is returned whenever the server responded,
+// even if err is not nil.
+func (c *Client) exec(ctx context.Context, op OperationType, v interface{}, variables interface{}, name string) (*Response, error) {
+	req, err := c.newRequest(op, v, variables, name)
 	if err != nil {
-		// TODO: Consider including response body in returned error, if deemed helpful.
 		return nil, err
 	}
+	if c.cache != nil {
+		return c.execCached(ctx, req, v)
+	}
+	return c.handler(c.send)(ctx, req)
+}
 
-	if len(out.Errors) > 0 {
-		return out.Data, out.Errors
+// send sends req to the GraphQL server and decodes the response envelope.
+// It's the innermost Handler of the middleware chain.
+func (c *Client) send(ctx context.Context, req *Request) (*Response, error) {
+	if uploads := findUploads(req.Variables); len(uploads) > 0 {
+		// Uploads are read while being sent, so they can't be retried.
+		return c.postMultipart(ctx, req, uploads)
 	}
+	if c.retryPolicy != nil && c.retryPolicy.allows(req) {
+		return c.retryPolicy.do(ctx, req, c.dispatch)
+	}
+	return c.dispatch(ctx, req)
+}
 
-	return out.Data, nil
+// dispatch sends req, either in a request of its own or batched with others.
+func (c *Client) dispatch(ctx context.Context, req *Request) (*Response, error) {
+	if c.batcher != nil && req.Type == QueryOperation {
+		// The response may be read after the caller returned,
+		// e.g. once its context is done.
+		return c.batcher.send(ctx, req.buffered())
+	}
+	return c.sendOne(ctx, req)
 }
 
-// do executes a single GraphQL operation and unmarshal json.
-func (c *Client) do(ctx context.Context, op operationType, v interface{}, variables map[string]interface{}, name string) error {
+// buffered returns req without target, so that its response data is
+// buffered in Response.Data, and decoded by the caller.
+func (req *Request) buffered() *Request {
+	if req.target == nil {
+		return req
+	}
+	r := *req
+	r.target = nil
+	return &r
+}
 
-	var query string
-	switch op {
-	case queryOperation:
-		query = constructQuery(v, variables, name)
-	case mutationOperation:
-		query = constructMutation(v, variables, name)
-	}
-	in := struct {
-		Query     string                 `json:"query"`
-		Variables map[string]interface{} `json:"variables,omitempty"`
-	}{
-		Query:     query,
-		Variables: variables,
+// sendOne sends req in a request of its own.
+func (c *Client) sendOne(ctx context.Context, req *Request) (*Response, error) {
+	if c.persistedQueries != nil && req.Query != "" && !c.persistedQueries.isUnsupported() {
+		return c.sendPersisted(ctx, req)
+	}
+	if c.getQueries && req.Type == QueryOperation {
+		return c.get(ctx, req)
 	}
+	return c.post(ctx, req)
+}
+
+// post sends req as JSON encoded body of a POST request.
+func (c *Client) post(ctx context.Context, req *Request) (*Response, error) {
 	var buf bytes.Buffer
-	err := json.NewEncoder(&buf).Encode(in)
+	err := json.NewEncoder(&buf).Encode(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	resp, err := ctxhttp.Post(ctx, c.httpClient, c.url, "application/json", &buf)
+	httpReq, err := http.NewRequest(http.MethodPost, c.url, &buf)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	return c.roundTrip(ctx, req, httpReq)
+}
+
+// get sends req as URL encoded parameters of a GET request.
+// If the resulting URL is longer than c.maxURLLength, req is sent
+// as a POST request instead.
+func (c *Client) get(ctx context.Context, req *Request) (*Response, error) {
+	u, err := url.Parse(c.url)
+	if err != nil {
+		return nil, err
+	}
+	params := u.Query()
+	if req.Query != "" {
+		params.Set("query", req.Query)
+	}
+	if len(req.Variables) > 0 {
+		b, err := json.Marshal(req.Variables)
+		if err != nil {
+			return nil, err
+		}
+		params.Set("variables", string(b))
+	}
+	if req.OperationName != "" {
+		params.Set("operationName", req.OperationName)
+	}
+	if len(req.Extensions) > 0 {
+		b, err := json.Marshal(req.Extensions)
+		if err != nil {
+			return nil, err
+		}
+		params.Set("extensions", string(b))
+	}
+	u.RawQuery = params.Encode()
+	getURL := u.String()
+	if c.maxURLLength > 0 && len(getURL) > c.maxURLLength {
+		return c.post(ctx, req)
+	}
+	httpReq, err := http.NewRequest(http.MethodGet, getURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.roundTrip(ctx, req, httpReq)
+}
+
+// roundTrip sends httpReq, carrying req, and decodes the response envelope.
+func (c *Client) roundTrip(ctx context.Context, req *Request, httpReq *http.Request) (*Response, error) {
+	for k, v := range req.Header {
+		httpReq.Header[k] = v
+	}
+	c.trace(ctx, req, TraceEvent{Kind: TraceHTTPSend, Size: httpReq.ContentLength})
+	start := time.Now()
+	resp, err := ctxhttp.Do(ctx, c.httpClient, httpReq)
+	if err != nil {
+		c.trace(ctx, req, TraceEvent{Kind: TraceHTTPReceive, Duration: time.Since(start), Err: err})
+		return nil, err
 	}
 	defer resp.Body.Close()
+	c.trace(ctx, req, TraceEvent{Kind: TraceHTTPReceive, Duration: time.Since(start), StatusCode: resp.StatusCode, Size: resp.ContentLength})
+	out := &Response{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+	}
 	if resp.StatusCode != http.StatusOK {
 		body, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("non-200 OK status code: %v body: %q", resp.Status, body)
+		return out, fmt.Errorf("non-200 OK status code: %v body: %q", resp.Status, body)
 	}
-	var out struct {
-		Data   *json.RawMessage
-		Errors errors
-		//Extensions interface{} // Unused.
+	start = time.Now()
+	body := &countingReader{r: resp.Body}
+	err = decodeEnvelope(body, req, out)
+	c.trace(ctx, req, TraceEvent{Kind: TraceDecode, Duration: time.Since(start), Size: body.n, Err: err})
+	return out, err
+}
+
+// decodeEnvelope decodes the response envelope of req from r into out.
+func decodeEnvelope(r io.Reader, req *Request, out *Response) error {
+	if req.target != nil {
+		return decodeStreaming(r, req.target, out)
+	}
+	var envelope struct {
+		Data       *json.RawMessage
+		Errors     Errors
+		Extensions map[string]interface{}
 	}
-	err = json.NewDecoder(resp.Body).Decode(&out)
+	err := json.NewDecoder(r).Decode(&envelope)
 	if err != nil {
 		// TODO: Consider including response body in returned error, if deemed helpful.
 		return err
 	}
-	if out.Data != nil {
-		err := jsonutil.UnmarshalGraphQL(*out.Data, v)
+	out.Data, out.Errors, out.Extensions = envelope.Data, envelope.Errors, envelope.Extensions
+	return nil
+}
+
+// decodeStreaming decodes the response envelope from r into out, decoding
+// the data directly into v rather than buffering it.
+func decodeStreaming(r io.Reader, v interface{}, out *Response) error {
+	members, err := jsonutil.DecodeGraphQLResponse(r, v)
+	if err != nil {
+		return err
+	}
+	if errs, ok := members["errors"]; ok {
+		err := json.Unmarshal(errs, &out.Errors)
 		if err != nil {
-			// TODO: Consider including response body in returned error, if deemed helpful.
 			return err
 		}
 	}
-	if len(out.Errors) > 0 {
-		return out.Errors
+	if extensions, ok := members["extensions"]; ok {
+		err := json.Unmarshal(extensions, &out.Extensions)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-// errors represents the "errors" array in a response from a GraphQL server.
+// doRaw executes a single GraphQL operation.
+// return raw message and error
+func (c *Client) doRaw(ctx context.Context, op OperationType, v interface{}, variables interface{}, name string) (*json.RawMessage, error) {
+	resp, err := c.exec(ctx, op, v, variables, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Errors) > 0 {
+		return resp.Data, resp.Errors
+	}
+	return resp.Data, nil
+}
+
+// do executes a single GraphQL operation and unmarshal json.
+func (c *Client) do(ctx context.Context, op OperationType, v interface{}, variables interface{}, name string) error {
+	req, err := c.newRequest(op, v, variables, name)
+	if err != nil {
+		return err
+	}
+	return c.run(ctx, req, v)
+}
+
+// run sends req, derived from query struct v, and decodes the response
+// data into v.
+func (c *Client) run(ctx context.Context, req *Request, v interface{}) error {
+	if c.cache != nil && isQueryStruct(v) {
+		resp, err := c.execCached(ctx, req, v)
+		return decodeResponse(resp, err, v)
+	}
+	return c.runUncached(ctx, req, v)
+}
+
+// runUncached sends req, bypassing the response cache, and decodes the
+// response data into v.
+func (c *Client) runUncached(ctx context.Context, req *Request, v interface{}) error {
+	if !isQueryStruct(v) || len(c.middlewares) > 0 {
+		// Plain JSON values can't be decoded as they're read, and
+		// middlewares may inspect or rewrite the response data.
+		resp, err := c.handler(c.send)(ctx, req)
+		return decodeResponse(resp, err, v)
+	}
+	// Decode the response data into v as it's read, so that large
+	// responses aren't held in memory twice.
+	req.target = v
+	resp, err := c.handler(c.send)(ctx, req)
+	return decodeResponse(resp, err, v)
+}
+
+// doResponse executes a single GraphQL operation, unmarshals data into v
+// and returns the response envelope.
+func (c *Client) doResponse(ctx context.Context, op OperationType, v interface{}, variables interface{}, name string) (*Response, error) {
+	resp, err := c.exec(ctx, op, v, variables, name)
+	return resp, decodeResponse(resp, err, v)
+}
+
+// Errors represents the "errors" array in a response from a GraphQL server.
 // If returned via error interface, the slice is expected to contain at least 1 element.
 //
 // Specification: https://facebook.github.io/graphql/#sec-Errors.
-type errors []struct {
+type Errors []Error
+
+// Error is a single entry of the "errors" array in a GraphQL response.
+type Error struct {
 	Message   string
-	Locations []struct {
-		Line   int
-		Column int
-	}
+	Locations []Location
+	// Path is the path of the response field which experienced the error.
+	// Its elements are field names (string) and list indices (float64).
+	Path []interface{}
+	// Extensions holds additional, server specific information about the error,
+	// such as an error code.
+	Extensions map[string]interface{}
+}
+
+// Location is a location in the GraphQL document associated with an error.
+type Location struct {
+	Line   int
+	Column int
 }
 
 // Error implements error interface.
-func (e errors) Error() string {
-	return e[0].Message
+func (e Error) Error() string {
+	return e.Message
 }
 
-type operationType uint8
+// Code returns the "code" entry of the error extensions, if any.
+func (e Error) Code() string {
+	code, _ := e.Extensions["code"].(string)
+	return code
+}
+
+// Error implements error interface.
+// The messages of all errors are combined, separated by semicolons.
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// OperationType is the type of a GraphQL operation.
+type OperationType uint8
 
 const (
-	queryOperation operationType = iota
-	mutationOperation
-	//subscriptionOperation // Unused.
+	QueryOperation OperationType = iota
+	MutationOperation
+	SubscriptionOperation
 )
+
+// String returns the GraphQL keyword of the operation type.
+func (op OperationType) String() string {
+	switch op {
+	case QueryOperation:
+		return "query"
+	case MutationOperation:
+		return "mutation"
+	case SubscriptionOperation:
+		return "subscription"
+	default:
+		return fmt.Sprintf("OperationType(%d)", op)
+	}
+}
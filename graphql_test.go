@@ -2,11 +2,16 @@ package graphql_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/InoiOy/go-graphql-client"
 )
@@ -113,6 +118,177 @@ func TestClient_Query_noDataWithErrorResponse(t *testing.T) {
 	}
 }
 
+// Test that the response data is decoded whatever the position
+// of errors and extensions in the response object.
+func TestClient_Query_errorsBeforeData(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{
+			"errors": [{"message": "Could not resolve to a node"}],
+			"data": {"user": {"name": "Gopher"}},
+			"extensions": {"cost": 1}
+		}`)
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}})
+
+	var q struct {
+		User struct {
+			Name graphql.String
+		}
+	}
+	err := client.Query(context.Background(), &q, nil)
+	if got, want := fmt.Sprint(err), "Could not resolve to a node"; got != want {
+		t.Errorf("got error: %v, want: %v", got, want)
+	}
+	if got, want := q.User.Name, graphql.String("Gopher"); got != want {
+		t.Errorf("got q.User.Name: %q, want: %q", got, want)
+	}
+}
+
+func TestClient_ExecString(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		body := mustRead(req.Body)
+		if got, want := body, `{"query":"query GetUser($login:String!){user(login: $login){name}}","variables":{"login":"gopher"}}`+"\n"; got != want {
+			t.Errorf("got body: %v, want %v", got, want)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {"user": {"name": "Gopher"}}}`)
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}})
+
+	var q struct {
+		User struct {
+			Name graphql.String
+		} `graphql:"user(login: $login)"`
+	}
+	variables := map[string]interface{}{
+		"login": graphql.String("gopher"),
+	}
+	query, _, err := graphql.ConstructQuery(&q, variables, "GetUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.ExecString(context.Background(), query, &q, variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := q.User.Name, graphql.String("Gopher"); got != want {
+		t.Errorf("got q.User.Name: %q, want: %q", got, want)
+	}
+}
+
+func TestClient_NamedExecString(t *testing.T) {
+	const document = `
+		fragment UserFields on User { name }
+		query GetUser($login: String!) { user(login: $login) { ...UserFields } }
+		mutation Rename($name: String!) { rename(name: $name) { ...UserFields } }
+	`
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		if got, want := req.Method, http.MethodGet; got != want {
+			t.Errorf("got method: %v, want: %v", got, want)
+		}
+		if got, want := req.URL.Query().Get("operationName"), "GetUser"; got != want {
+			t.Errorf("got operationName: %q, want: %q", got, want)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {"user": {"name": "Gopher"}}, "extensions": {"cost": 1}}`)
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}}).
+		WithGETQueries(0)
+
+	var q struct {
+		User struct {
+			Name graphql.String
+		}
+	}
+	variables := map[string]interface{}{"login": "gopher"}
+	err := client.NamedExecString(context.Background(), document, "GetUser", &q, variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := q.User.Name, graphql.String("Gopher"); got != want {
+		t.Errorf("got q.User.Name: %q, want: %q", got, want)
+	}
+
+	var m map[string]interface{}
+	err = client.NamedExecString(context.Background(), document, "GetUser", &m, variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m, map[string]interface{}{"user": map[string]interface{}{"name": "Gopher"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got m: %v, want: %v", got, want)
+	}
+
+	err = client.ExecString(context.Background(), document, &m, variables)
+	if got, want := fmt.Sprint(err), "document has 2 operations, an operation name is required"; got != want {
+		t.Errorf("got error: %v, want: %v", got, want)
+	}
+}
+
+func TestClient_Query_errorsWithExtensions(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{
+			"data": null,
+			"errors": [
+				{
+					"message": "not authenticated",
+					"path": ["viewer", "repositories", 1, "name"],
+					"locations": [{"line": 1, "column": 2}],
+					"extensions": {"code": "UNAUTHENTICATED", "retryable": false}
+				},
+				{
+					"message": "rate limited"
+				}
+			]
+		}`)
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}})
+
+	var q struct {
+		Viewer struct {
+			Login graphql.String
+		}
+	}
+	err := client.Query(context.Background(), &q, nil)
+	if err == nil {
+		t.Fatal("got error: nil, want: non-nil")
+	}
+	if got, want := err.Error(), "not authenticated; rate limited"; got != want {
+		t.Errorf("got error: %v, want: %v", got, want)
+	}
+	var gqlErrs graphql.Errors
+	if !errors.As(fmt.Errorf("wrapped: %w", err), &gqlErrs) {
+		t.Fatalf("errors.As(%T) = false, want true", err)
+	}
+	want := graphql.Errors{
+		{
+			Message:    "not authenticated",
+			Path:       []interface{}{"viewer", "repositories", float64(1), "name"},
+			Locations:  []graphql.Location{{Line: 1, Column: 2}},
+			Extensions: map[string]interface{}{"code": "UNAUTHENTICATED", "retryable": false},
+		},
+		{
+			Message: "rate limited",
+		},
+	}
+	if !reflect.DeepEqual(gqlErrs, want) {
+		t.Errorf("got errors: %#v, want: %#v", gqlErrs, want)
+	}
+	if got, want := gqlErrs[0].Code(), "UNAUTHENTICATED"; got != want {
+		t.Errorf("got code: %q, want: %q", got, want)
+	}
+
+	_, err = client.QueryRaw(context.Background(), &q, nil)
+	if !errors.As(err, &gqlErrs) {
+		t.Errorf("QueryRaw: errors.As(%T) = false, want true", err)
+	}
+}
+
 func TestClient_Query_errorStatusCode(t *testing.T) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
@@ -137,6 +313,280 @@ func TestClient_Query_errorStatusCode(t *testing.T) {
 	}
 }
 
+func TestClient_Exec(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Request-Id", "abc")
+		mustWrite(w, `{
+			"extensions": {"cost": {"requested": 3, "remaining": 997}},
+			"data": {"user": {"name": "Gopher"}}
+		}`)
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}})
+
+	var q struct {
+		User struct {
+			Name graphql.String
+		}
+	}
+	resp, err := client.Exec(context.Background(), graphql.QueryOperation, &q, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := q.User.Name, graphql.String("Gopher"); got != want {
+		t.Errorf("got q.User.Name: %q, want: %q", got, want)
+	}
+	if got, want := string(*resp.Data), `{"user": {"name": "Gopher"}}`; got != want {
+		t.Errorf("got resp.Data: %s, want: %s", got, want)
+	}
+	wantExtensions := map[string]interface{}{
+		"cost": map[string]interface{}{"requested": float64(3), "remaining": float64(997)},
+	}
+	if !reflect.DeepEqual(resp.Extensions, wantExtensions) {
+		t.Errorf("got resp.Extensions: %v, want: %v", resp.Extensions, wantExtensions)
+	}
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Errorf("got resp.StatusCode: %v, want: %v", got, want)
+	}
+	if got, want := resp.Header.Get("X-Request-Id"), "abc"; got != want {
+		t.Errorf("got X-Request-Id header: %q, want: %q", got, want)
+	}
+}
+
+func TestClient_Exec_errorStatusCode(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}})
+
+	var q struct {
+		User struct {
+			Name graphql.String
+		}
+	}
+	resp, err := client.NamedExec(context.Background(), graphql.QueryOperation, "GetUser", &q, nil)
+	if err == nil {
+		t.Fatal("got error: nil, want: non-nil")
+	}
+	if resp == nil {
+		t.Fatal("got resp: nil, want: non-nil")
+	}
+	if got, want := resp.StatusCode, http.StatusTooManyRequests; got != want {
+		t.Errorf("got resp.StatusCode: %v, want: %v", got, want)
+	}
+	if got, want := resp.Header.Get("Retry-After"), "10"; got != want {
+		t.Errorf("got Retry-After header: %q, want: %q", got, want)
+	}
+}
+
+func TestClient_WithGETQueries(t *testing.T) {
+	var methods []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		methods = append(methods, req.Method)
+		if req.Method == http.MethodGet {
+			params := req.URL.Query()
+			if got, want := params.Get("query"), `query GetUser($login:String!){user(login: $login){name}}`; got != want {
+				t.Errorf("got query: %q, want: %q", got, want)
+			}
+			if got, want := params.Get("variables"), `{"login":"gopher"}`; got != want {
+				t.Errorf("got variables: %q, want: %q", got, want)
+			}
+			if got, want := params.Get("operationName"), "GetUser"; got != want {
+				t.Errorf("got operationName: %q, want: %q", got, want)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {"user": {"name": "Gopher"}}}`)
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}}).
+		WithGETQueries(200)
+
+	var q struct {
+		User struct {
+			Name graphql.String
+		} `graphql:"user(login: $login)"`
+	}
+	err := client.NamedQuery(context.Background(), "GetUser", &q, map[string]interface{}{"login": graphql.String("gopher")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := q.User.Name, graphql.String("Gopher"); got != want {
+		t.Errorf("got q.User.Name: %q, want: %q", got, want)
+	}
+
+	// Mutations are never sent as GET.
+	err = client.Mutate(context.Background(), &q, map[string]interface{}{"login": graphql.String("gopher")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Queries whose URL would exceed the limit fall back to POST.
+	err = client.Query(context.Background(), &q, map[string]interface{}{"login": graphql.String(strings.Repeat("x", 200))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{http.MethodGet, http.MethodPost, http.MethodPost}; !reflect.DeepEqual(methods, want) {
+		t.Errorf("got methods: %v, want: %v", methods, want)
+	}
+}
+
+func TestClient_WithTypeName(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		body := mustRead(req.Body)
+		if got, want := body, `{"query":"query ($id:ID$since:Date$states:[String!]!){user(id: $id){name}}","variables":{"id":4,"since":null,"states":["OPEN"]}}`+"\n"; got != want {
+			t.Errorf("got body: %v, want %v", got, want)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {"user": {"name": "Gopher"}}}`)
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}}).
+		WithTypeName(time.Time{}, "Date")
+
+	var q struct {
+		User struct {
+			Name string
+		} `graphql:"user(id: $id)"`
+	}
+	variables := map[string]interface{}{
+		"id":     graphql.NewID(4),
+		"since":  (*time.Time)(nil),
+		"states": []string{"OPEN"},
+	}
+	err := client.Query(context.Background(), &q, variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := q.User.Name, "Gopher"; got != want {
+		t.Errorf("got q.User.Name: %q, want: %q", got, want)
+	}
+
+	// The client's builder knows the type names too.
+	query, definitions, err := client.ConstructQuery(&q, variables, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := query, `query ($id:ID$since:Date$states:[String!]!){user(id: $id){name}}`; got != want {
+		t.Errorf("got query: %q, want: %q", got, want)
+	}
+	if got, want := definitions[1], (graphql.VariableDefinition{Name: "since", Type: "Date"}); got != want {
+		t.Errorf("got definition: %+v, want: %+v", got, want)
+	}
+	subscription, _, err := client.ConstructSubscription(&q, variables, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := subscription, `subscription ($id:ID$since:Date$states:[String!]!){user(id: $id){name}}`; got != want {
+		t.Errorf("got subscription: %q, want: %q", got, want)
+	}
+}
+
+func TestClient_Query_keyedFields(t *testing.T) {
+	var requests int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		body := mustRead(req.Body)
+		if got, want := body, `{"query":"query ($owner:String!){repositories_0: repository(owner: $owner, name: \"go\"){stargazerCount},repositories_1: repository(owner: $owner, name: \"tools\"){stargazerCount}}","variables":{"owner":"golang"}}`+"\n"; got != want {
+			t.Errorf("got body: %v, want %v", got, want)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {"repositories_0": {"stargazerCount": 120000}, "repositories_1": {"stargazerCount": 7000}}}`)
+	})
+	// Queries with keyed fields bypass the cache.
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}}).
+		WithCache(graphql.NewCache(), graphql.CacheFirst)
+
+	type repository struct {
+		Stars graphql.Int `graphql:"stargazerCount"`
+	}
+	for i := 0; i < 2; i++ {
+		q := struct {
+			Repositories map[string]repository `graphql:"repository(owner: $owner, name: $key)"`
+		}{
+			Repositories: map[string]repository{"go": {}, "tools": {}},
+		}
+		err := client.Query(context.Background(), &q, map[string]interface{}{"owner": "golang"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[string]repository{"go": {Stars: 120000}, "tools": {Stars: 7000}}; !reflect.DeepEqual(q.Repositories, want) {
+			t.Errorf("got q.Repositories: %+v, want: %+v", q.Repositories, want)
+		}
+	}
+	if got, want := requests, 2; got != want {
+		t.Errorf("got %d requests, want: %d", got, want)
+	}
+}
+
+type pet interface {
+	petName() string
+}
+
+type cat struct {
+	Name  string
+	Lives int
+}
+
+func (c cat) petName() string { return c.Name }
+
+type dog struct {
+	Name  string
+	Breed string
+}
+
+func (d dog) petName() string { return d.Name }
+
+func TestClient_Query_interfaces(t *testing.T) {
+	graphql.RegisterType("Cat", cat{})
+	graphql.RegisterType("Dog", dog{})
+	graphql.RegisterPossibleTypes("Pet", "Cat", "Dog")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		body := mustRead(req.Body)
+		if got, want := body, `{"query":"{pets{__typename,... on Cat{name,lives},... on Dog{name,breed}},owner{__typename,name,... on Pet{name}}}"}`+"\n"; got != want {
+			t.Errorf("got body: %v, want %v", got, want)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {
+			"pets": [
+				{"__typename": "Dog", "name": "Rex", "breed": "Beagle"},
+				{"__typename": "Cat", "name": "Tom", "lives": 9}
+			],
+			"owner": {"__typename": "User", "name": "Gopher"}
+		}}`)
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}})
+
+	var q struct {
+		Pets  []pet
+		Owner struct {
+			Name string
+			Pet  struct {
+				Name string
+			} `graphql:"... on Pet"`
+		}
+	}
+	err := client.Query(context.Background(), &q, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []pet{dog{Name: "Rex", Breed: "Beagle"}, cat{Name: "Tom", Lives: 9}}; !reflect.DeepEqual(q.Pets, want) {
+		t.Errorf("got q.Pets: %+v, want: %+v", q.Pets, want)
+	}
+	if got, want := q.Owner.Name, "Gopher"; got != want {
+		t.Errorf("got q.Owner.Name: %q, want: %q", got, want)
+	}
+	if got, want := q.Owner.Pet.Name, ""; got != want {
+		t.Errorf("got q.Owner.Pet.Name: %q, want: %q", got, want)
+	}
+}
+
 // Test that an empty (but non-nil) variables map is
 // handled no differently than a nil variables map.
 func TestClient_Query_emptyVariables(t *testing.T) {
@@ -0,0 +1,390 @@
+// Package graphqltest provides an http.RoundTripper that records GraphQL
+// exchanges to golden files and replays them, for deterministic tests of
+// code using a GraphQL client.
+//
+// Requests are matched on their normalized operation: the query with
+// insignificant whitespace, commas and variable definition order removed,
+// the variables compared as JSON values, the operation name and extensions.
+// Matching doesn't depend on the bytes of the request, such as the order
+// of variables in the request body.
+//
+// Only single operations are supported: batched operations and file uploads
+// fail with an error naming the unsupported request body.
+package graphqltest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Mode is the mode of a Recorder.
+type Mode uint8
+
+const (
+	// Replay serves requests from the golden file, without network access.
+	Replay Mode = iota
+	// Record sends requests to the server and records the exchanges.
+	Record
+)
+
+// Exchange is a recorded GraphQL request and its response.
+type Exchange struct {
+	Request  Operation `json:"request"`
+	Response Response  `json:"response"`
+}
+
+// Operation is a GraphQL operation sent in a request.
+type Operation struct {
+	Query         string                 `json:"query,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Extensions    map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// Response is a recorded HTTP response.
+type Response struct {
+	StatusCode  int             `json:"statusCode"`
+	ContentType string          `json:"contentType,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"` // Body, if it's valid JSON.
+	Text        string          `json:"text,omitempty"` // Body otherwise.
+}
+
+// Recorder is an http.RoundTripper that records GraphQL exchanges to
+// a golden file, or replays them from it.
+//
+// When replaying, exchanges of identical operations are served in the order
+// they were recorded, the last one being served again once all have been.
+type Recorder struct {
+	mode      Mode
+	path      string
+	transport http.RoundTripper
+
+	mu        sync.Mutex
+	exchanges []Exchange
+	served    map[string]int // Number of times each operation was served.
+}
+
+// NewRecorder returns a Recorder in mode, using the golden file at path.
+// In Replay mode, the golden file is read. In Record mode, requests are sent
+// through transport, or http.DefaultTransport if nil, and Save writes the
+// golden file.
+func NewRecorder(path string, mode Mode, transport http.RoundTripper) (*Recorder, error) {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	r := &Recorder{
+		mode:      mode,
+		path:      path,
+		transport: transport,
+		served:    make(map[string]int),
+	}
+	if mode == Replay {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(b, &r.exchanges)
+		if err != nil {
+			return nil, fmt.Errorf("graphqltest: %s: %v", path, err)
+		}
+	}
+	return r, nil
+}
+
+// New returns a Recorder for test t, failing it on error. In Record mode,
+// the golden file is saved when t finishes.
+func New(t testing.TB, path string, mode Mode, transport http.RoundTripper) *Recorder {
+	t.Helper()
+	r, err := NewRecorder(path, mode, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode == Record {
+		t.Cleanup(func() {
+			if err := r.Save(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	return r
+}
+
+// Exchanges returns the exchanges recorded or loaded so far.
+func (r *Recorder) Exchanges() []Exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Exchange(nil), r.exchanges...)
+}
+
+// Save writes the recorded exchanges to the golden file, creating
+// its directory if needed.
+func (r *Recorder) Save() error {
+	r.mu.Lock()
+	b, err := json.MarshalIndent(r.exchanges, "", "\t")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.path, append(b, '\n'), 0644)
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	op, err := readOperation(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.mode == Record {
+		return r.record(req, op)
+	}
+	return r.replay(req, op)
+}
+
+func (r *Recorder) record(req *http.Request, op Operation) (*http.Response, error) {
+	resp, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	recorded := Response{
+		StatusCode:  resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	if json.Valid(body) {
+		recorded.Body = body
+	} else {
+		recorded.Text = string(body)
+	}
+	r.mu.Lock()
+	r.exchanges = append(r.exchanges, Exchange{Request: op, Response: recorded})
+	r.mu.Unlock()
+	return resp, nil
+}
+
+func (r *Recorder) replay(req *http.Request, op Operation) (*http.Response, error) {
+	key := op.key()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var matches []Response
+	for _, e := range r.exchanges {
+		if e.Request.key() == key {
+			matches = append(matches, e.Response)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("graphqltest: no recorded response for operation %q in %s: %s", op.OperationName, r.path, NormalizeQuery(op.Query))
+	}
+	i := r.served[key]
+	if i >= len(matches) {
+		i = len(matches) - 1
+	}
+	r.served[key]++
+	recorded := matches[i]
+	body := []byte(recorded.Body)
+	if recorded.Body == nil {
+		body = []byte(recorded.Text)
+	}
+	header := make(http.Header)
+	if recorded.ContentType != "" {
+		header.Set("Content-Type", recorded.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+		StatusCode:    recorded.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// readOperation reads the GraphQL operation of req, sent either as
+// a GET request or as a POST request with a JSON body. The body of req
+// is restored so that it can be sent.
+//
+// Batched operations, sent as a JSON array, and file uploads, sent as
+// multipart/form-data, aren't supported.
+func readOperation(req *http.Request) (Operation, error) {
+	var op Operation
+	if req.Method == http.MethodGet {
+		q := req.URL.Query()
+		op.Query, op.OperationName = q.Get("query"), q.Get("operationName")
+		for name, dst := range map[string]*map[string]interface{}{"variables": &op.Variables, "extensions": &op.Extensions} {
+			if v := q.Get(name); v != "" {
+				if err := json.Unmarshal([]byte(v), dst); err != nil {
+					return op, fmt.Errorf("graphqltest: invalid %s: %v", name, err)
+				}
+			}
+		}
+		return op, nil
+	}
+	if req.Body == nil {
+		return op, errors.New("graphqltest: request without body")
+	}
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		return op, errors.New("graphqltest: unsupported request body: multipart/form-data (file upload)")
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return op, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		return op, errors.New("graphqltest: unsupported request body: JSON array (batched operations)")
+	}
+	if err := json.Unmarshal(body, &op); err != nil {
+		return op, fmt.Errorf("graphqltest: unsupported request body: %v", err)
+	}
+	return op, nil
+}
+
+// key returns the key op is matched on.
+func (op Operation) key() string {
+	canonical := func(m map[string]interface{}) string {
+		if len(m) == 0 {
+			return ""
+		}
+		b, _ := json.Marshal(m) // Map keys are sorted.
+		return string(b)
+	}
+	variables, extensions := canonical(op.Variables), canonical(op.Extensions)
+	return strings.Join([]string{NormalizeQuery(op.Query), op.OperationName, variables, extensions}, "\x00")
+}
+
+// NormalizeQuery returns query without insignificant whitespace and commas,
+// and with the variable definitions of the operation sorted by name.
+func NormalizeQuery(query string) string {
+	tokens := tokenize(query)
+	// Sort the variable definitions, enclosed in the first parentheses
+	// before the selection set.
+	for i, tok := range tokens {
+		if tok == "{" {
+			break
+		}
+		if tok != "(" {
+			continue
+		}
+		end := i + 1
+		for end < len(tokens) && tokens[end] != ")" {
+			end++
+		}
+		var defs [][]string
+		for _, tok := range tokens[i+1 : end] {
+			if tok == "$" || len(defs) == 0 {
+				defs = append(defs, nil)
+			}
+			defs[len(defs)-1] = append(defs[len(defs)-1], tok)
+		}
+		sort.SliceStable(defs, func(a, b int) bool {
+			return strings.Join(defs[a], " ") < strings.Join(defs[b], " ")
+		})
+		sorted := tokens[: i+1 : i+1]
+		for _, def := range defs {
+			sorted = append(sorted, def...)
+		}
+		tokens = append(sorted, tokens[end:]...)
+		break
+	}
+	var b strings.Builder
+	for i, tok := range tokens {
+		if i > 0 && isNameToken(tokens[i-1]) && isNameToken(tok) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(tok)
+	}
+	return b.String()
+}
+
+// tokenize splits query into GraphQL lexical tokens, dropping
+// whitespace, commas and comments.
+func tokenize(query string) []string {
+	var tokens []string
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case c == '"':
+			j := i + 1
+			if strings.HasPrefix(query[i:], `"""`) {
+				j = i + 3
+				for j < len(query) && !strings.HasPrefix(query[j:], `"""`) {
+					j++
+				}
+				j += 3
+			} else {
+				for j < len(query) && query[j] != '"' {
+					if query[j] == '\\' {
+						j++
+					}
+					j++
+				}
+				j++
+			}
+			if j > len(query) {
+				j = len(query)
+			}
+			tokens = append(tokens, query[i:j])
+			i = j
+		case c == '.' && strings.HasPrefix(query[i:], "..."):
+			tokens = append(tokens, "...")
+			i += 3
+		case isNameChar(c):
+			j := i
+			for j < len(query) && isNameChar(query[j]) {
+				j++
+			}
+			tokens = append(tokens, query[i:j])
+			i = j
+		case c == '-' || c >= '0' && c <= '9':
+			// Number.
+			j := i + 1
+			for j < len(query) && (isNameChar(query[j]) || query[j] == '.' || query[j] == '+' || query[j] == '-') {
+				j++
+			}
+			tokens = append(tokens, query[i:j])
+			i = j
+		default:
+			tokens = append(tokens, query[i:i+1])
+			i++
+		}
+	}
+	return tokens
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// isNameToken reports whether tok is a name or a number, which
+// must be separated from an adjacent name or number.
+func isNameToken(tok string) bool {
+	return tok != "" && (isNameChar(tok[0]) || tok[0] == '-')
+}
@@ -0,0 +1,162 @@
+package graphqltest_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/InoiOy/go-graphql-client"
+	"github.com/InoiOy/go-graphql-client/graphqltest"
+)
+
+type userQuery struct {
+	User struct {
+		Name      graphql.String
+		Followers struct {
+			TotalCount graphql.Int
+		} `graphql:"followers(first: $first)"`
+	} `graphql:"user(login: $login)"`
+}
+
+func TestRecorder_replay(t *testing.T) {
+	rt := graphqltest.New(t, "testdata/user.json", graphqltest.Replay, nil)
+	client := graphql.NewClient("https://example.invalid/graphql", &http.Client{Transport: rt})
+
+	var q userQuery
+	err := client.NamedQuery(context.Background(), "GetUser", &q, map[string]interface{}{
+		"first": graphql.Int(10),
+		"login": graphql.String("gopher"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := q.User.Name, graphql.String("Gopher"); got != want {
+		t.Errorf("got q.User.Name: %q, want: %q", got, want)
+	}
+	if got, want := q.User.Followers.TotalCount, graphql.Int(42); got != want {
+		t.Errorf("got q.User.Followers.TotalCount: %v, want: %v", got, want)
+	}
+
+	err = client.NamedQuery(context.Background(), "GetUser", &q, map[string]interface{}{
+		"first": graphql.Int(20),
+		"login": graphql.String("gopher"),
+	})
+	if err == nil || !strings.Contains(err.Error(), "no recorded response") {
+		t.Errorf("got error: %v, want: no recorded response", err)
+	}
+}
+
+func TestRecorder_record(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": {"user": {"name": "Gopher", "followers": {"totalCount": 42}}}}`))
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "graphqltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "user.json")
+	variables := map[string]interface{}{
+		"first": graphql.Int(10),
+		"login": graphql.String("gopher"),
+	}
+
+	rec, err := graphqltest.NewRecorder(path, graphqltest.Record, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recorded userQuery
+	err = graphql.NewClient(server.URL, &http.Client{Transport: rec}).Query(context.Background(), &recorded, variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := graphqltest.NewRecorder(path, graphqltest.Replay, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var replayed userQuery
+	err = graphql.NewClient(server.URL, &http.Client{Transport: rep}).Query(context.Background(), &replayed, variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := requests, 1; got != want {
+		t.Errorf("got %d requests, want: %d", got, want)
+	}
+	if !reflect.DeepEqual(replayed, recorded) {
+		t.Errorf("got replayed: %+v, want: %+v", replayed, recorded)
+	}
+}
+
+func TestRecorder_unsupportedBody(t *testing.T) {
+	rt := graphqltest.New(t, "testdata/user.json", graphqltest.Replay, nil)
+	client := graphql.NewClient("https://example.invalid/graphql", &http.Client{Transport: rt})
+
+	var q1, q2 userQuery
+	variables := map[string]interface{}{
+		"first": graphql.Int(10),
+		"login": graphql.String("gopher"),
+	}
+	_, err := client.Batch(context.Background(), []graphql.BatchOperation{
+		{Type: graphql.QueryOperation, Value: &q1, Variables: variables},
+		{Type: graphql.QueryOperation, Value: &q2, Variables: variables},
+	})
+	if err == nil || !strings.Contains(err.Error(), "unsupported request body: JSON array") {
+		t.Errorf("got error: %v, want: unsupported request body: JSON array", err)
+	}
+
+	var m struct {
+		Attach struct {
+			Count graphql.Int
+		} `graphql:"attach(file: $file)"`
+	}
+	err = client.Mutate(context.Background(), &m, map[string]interface{}{
+		"file": graphql.Upload{File: strings.NewReader("content"), FileName: "file.txt"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "unsupported request body: multipart/form-data") {
+		t.Errorf("got error: %v, want: unsupported request body: multipart/form-data", err)
+	}
+}
+
+func TestNormalizeQuery(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{
+			in:   `query ($login:String!$first:Int){user(login: $login){name,followers(first: $first){totalCount}}}`,
+			want: `query($first:Int$login:String!){user(login:$login){name followers(first:$first){totalCount}}}`,
+		},
+		{
+			in: `query ($first: Int, $login: String!) {
+				user(login: $login) {
+					name # The user's name.
+					followers(first: $first) { totalCount }
+				}
+			}`,
+			want: `query($first:Int$login:String!){user(login:$login){name followers(first:$first){totalCount}}}`,
+		},
+		{
+			in:   `{search(query: "a,  b", first: -1.5e3){... on User{login}}}`,
+			want: `{search(query:"a,  b"first:-1.5e3){...on User{login}}}`,
+		},
+	}
+	for _, tc := range tests {
+		if got := graphqltest.NormalizeQuery(tc.in); got != tc.want {
+			t.Errorf("\ngot:  %q\nwant: %q\n", got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"time"
+
+	"github.com/InoiOy/go-graphql-client/internal/jsonutil"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+// Incremental delivery follows the GraphQL over HTTP incremental delivery RFC
+// https://github.com/graphql/graphql-spec/pull/742
+
+// IncrementalPayload is a single payload of an incremental response,
+// as sent by servers for operations using the @defer and @stream directives.
+type IncrementalPayload struct {
+	// Data is the data of the initial payload. It's nil for subsequent payloads.
+	Data *json.RawMessage
+	// Incremental holds the patches of subsequent payloads.
+	Incremental []IncrementalPatch
+	Errors      Errors
+	Extensions  map[string]interface{}
+	// HasNext reports whether more payloads are to follow.
+	HasNext bool
+}
+
+// IncrementalPatch is the result of a deferred fragment or streamed list items.
+type IncrementalPatch struct {
+	// Path is the response path the patch applies to. For streamed items,
+	// it's the path of the list element of the first item.
+	Path  []interface{}
+	Label string
+	// Data is the data of a deferred fragment.
+	Data *json.RawMessage
+	// Items are the streamed list items.
+	Items      []json.RawMessage
+	Errors     Errors
+	Extensions map[string]interface{}
+}
+
+// QueryIncremental executes a single GraphQL query request that may use
+// the @defer and @stream directives, with a query derived from q, populating
+// the response into it as payloads arrive. q should be a pointer to struct
+// that corresponds to the GraphQL schema.
+//
+// fn, if not nil, is called after each payload has been applied to q, and
+// can inspect the raw payload, including whether more payloads are to follow.
+// If fn returns an error, the request is canceled and QueryIncremental
+// returns that error. fn is not called concurrently.
+//
+// Incremental queries go through middlewares, but not through batching,
+// persisted queries, retries or the cache. Servers that don't support
+// incremental delivery reply with a single JSON payload, which is handled
+// like any other.
+func (c *Client) QueryIncremental(ctx context.Context, q interface{}, variables interface{}, fn func(payload *IncrementalPayload) error) error {
+	return c.doIncremental(ctx, q, variables, "", fn)
+}
+
+// NamedQueryIncremental executes a single GraphQL query request, with operation name,
+// that may use the @defer and @stream directives. See QueryIncremental.
+func (c *Client) NamedQueryIncremental(ctx context.Context, name string, q interface{}, variables interface{}, fn func(payload *IncrementalPayload) error) error {
+	return c.doIncremental(ctx, q, variables, name, fn)
+}
+
+func (c *Client) doIncremental(ctx context.Context, q interface{}, variables interface{}, name string, fn func(payload *IncrementalPayload) error) error {
+	req, err := c.newRequest(QueryOperation, q, variables, name)
+	if err != nil {
+		return err
+	}
+	var fnErr error
+	h := c.handler(func(ctx context.Context, req *Request) (*Response, error) {
+		return c.postIncremental(ctx, req, func(payload *IncrementalPayload) error {
+			if err := applyIncremental(q, payload); err != nil {
+				return err
+			}
+			if fn == nil {
+				return nil
+			}
+			fnErr = fn(payload)
+			return fnErr
+		})
+	})
+	resp, err := h(ctx, req)
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		return resp.Errors
+	}
+	return nil
+}
+
+// applyIncremental applies the data of payload to v.
+func applyIncremental(v interface{}, payload *IncrementalPayload) error {
+	if payload.Data != nil {
+		err := jsonutil.UnmarshalGraphQL(*payload.Data, v)
+		if err != nil {
+			return err
+		}
+	}
+	for _, patch := range payload.Incremental {
+		if patch.Data != nil {
+			err := jsonutil.UnmarshalGraphQLAt(*patch.Data, v, patch.Path)
+			if err != nil {
+				return err
+			}
+		}
+		if len(patch.Items) == 0 {
+			continue
+		}
+		if len(patch.Path) == 0 {
+			return fmt.Errorf("streamed items without list index in path")
+		}
+		first, ok := patch.Path[len(patch.Path)-1].(float64)
+		if !ok {
+			return fmt.Errorf("streamed items path %v doesn't end with a list index", patch.Path)
+		}
+		path := append([]interface{}{}, patch.Path...)
+		for i, item := range patch.Items {
+			path[len(path)-1] = first + float64(i)
+			err := jsonutil.UnmarshalGraphQLAt(item, v, path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// postIncremental sends req and calls fn for each payload of the response.
+// The returned Response holds the data of the initial payload, the errors of
+// all payloads and the extensions of the last one.
+func (c *Client) postIncremental(ctx context.Context, req *Request, fn func(payload *IncrementalPayload) error) (*Response, error) {
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, c.url, &buf)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "multipart/mixed; deferSpec=20220824, application/json")
+	for k, v := range req.Header {
+		httpReq.Header[k] = v
+	}
+	c.trace(ctx, req, TraceEvent{Kind: TraceHTTPSend, Size: httpReq.ContentLength})
+	start := time.Now()
+	resp, err := ctxhttp.Do(ctx, c.httpClient, httpReq)
+	if err != nil {
+		c.trace(ctx, req, TraceEvent{Kind: TraceHTTPReceive, Duration: time.Since(start), Err: err})
+		return nil, err
+	}
+	defer resp.Body.Close()
+	c.trace(ctx, req, TraceEvent{Kind: TraceHTTPReceive, Duration: time.Since(start), StatusCode: resp.StatusCode, Size: resp.ContentLength})
+	out := &Response{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return out, fmt.Errorf("non-200 OK status code: %v body: %q", resp.Status, body)
+	}
+	handle := func(r io.Reader) (hasNext bool, err error) {
+		start := time.Now()
+		body := &countingReader{r: r}
+		payload, err := decodeIncremental(body)
+		c.trace(ctx, req, TraceEvent{Kind: TraceDecode, Duration: time.Since(start), Size: body.n, Err: err})
+		if err != nil {
+			return false, err
+		} else if payload == nil {
+			return true, nil
+		}
+		if payload.Data != nil {
+			out.Data = payload.Data
+		}
+		out.Errors = append(out.Errors, payload.Errors...)
+		for _, patch := range payload.Incremental {
+			out.Errors = append(out.Errors, patch.Errors...)
+		}
+		if payload.Extensions != nil {
+			out.Extensions = payload.Extensions
+		}
+		return payload.HasNext, fn(payload)
+	}
+	mediaType, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "multipart/mixed" {
+		_, err := handle(resp.Body)
+		return out, err
+	}
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return out, nil
+		} else if err != nil {
+			return out, err
+		}
+		hasNext, err := handle(part)
+		if err != nil || !hasNext {
+			return out, err
+		}
+	}
+}
+
+// decodeIncremental decodes a single payload from r. It returns a nil payload
+// for empty keep-alive payloads.
+func decodeIncremental(r io.Reader) (*IncrementalPayload, error) {
+	var envelope struct {
+		IncrementalPayload
+		// Path, Label and Items are set by servers implementing earlier drafts
+		// of the specification, which send a single patch per payload.
+		Path  []interface{}
+		Label string
+		Items []json.RawMessage
+	}
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(body)) == 0 || bytes.Equal(bytes.TrimSpace(body), []byte("{}")) {
+		// Keep-alive.
+		return nil, nil
+	}
+	err = json.Unmarshal(body, &envelope)
+	if err != nil {
+		return nil, err
+	}
+	payload := envelope.IncrementalPayload
+	if envelope.Path != nil {
+		payload.Incremental = append(payload.Incremental, IncrementalPatch{
+			Path:   envelope.Path,
+			Label:  envelope.Label,
+			Data:   payload.Data,
+			Items:  envelope.Items,
+			Errors: payload.Errors,
+		})
+		payload.Data, payload.Errors = nil, nil
+	}
+	return &payload, nil
+}
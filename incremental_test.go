@@ -0,0 +1,114 @@
+package graphql_test
+
+import (
+	"context"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"reflect"
+	"testing"
+
+	"github.com/InoiOy/go-graphql-client"
+)
+
+func TestClient_QueryIncremental(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		if got, want := req.Header.Get("Accept"), "multipart/mixed; deferSpec=20220824, application/json"; got != want {
+			t.Errorf("got Accept: %q, want: %q", got, want)
+		}
+		body := mustRead(req.Body)
+		if got, want := body, `{"query":"{user{id,... @defer{bio},friends @stream(initialCount: 1){name}}}"}`+"\n"; got != want {
+			t.Errorf("got body: %q, want %q", got, want)
+		}
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", fmt.Sprintf("multipart/mixed; boundary=%q", mw.Boundary()))
+		for _, payload := range []string{
+			`{"data": {"user": {"id": "1", "friends": [{"name": "Alice"}]}}, "hasNext": true}`,
+			`{}`,
+			`{"incremental": [{"path": ["user"], "data": {"bio": "Gopher"}}], "hasNext": true}`,
+			`{"incremental": [{"path": ["user", "friends", 1], "items": [{"name": "Bob"}, {"name": "Carol"}]}], "hasNext": false}`,
+		} {
+			part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json; charset=utf-8"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			mustWrite(part, payload)
+		}
+		if err := mw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}})
+
+	var q struct {
+		User struct {
+			ID       graphql.ID
+			Deferred struct {
+				Bio graphql.String
+			} `graphql:"... @defer"`
+			Friends []struct {
+				Name graphql.String
+			} `graphql:"friends @stream(initialCount: 1)"`
+		}
+	}
+	var hasNext []bool
+	err := client.QueryIncremental(context.Background(), &q, nil, func(payload *graphql.IncrementalPayload) error {
+		hasNext = append(hasNext, payload.HasNext)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hasNext, []bool{true, true, false}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got hasNext: %v, want: %v", got, want)
+	}
+	if got, want := q.User.ID, graphql.ID("1"); got != want {
+		t.Errorf("got q.User.ID: %q, want: %q", got, want)
+	}
+	if got, want := q.User.Deferred.Bio, graphql.String("Gopher"); got != want {
+		t.Errorf("got q.User.Deferred.Bio: %q, want: %q", got, want)
+	}
+	var friends []graphql.String
+	for _, f := range q.User.Friends {
+		friends = append(friends, f.Name)
+	}
+	if got, want := friends, []graphql.String{"Alice", "Bob", "Carol"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got friends: %q, want: %q", got, want)
+	}
+}
+
+// Test that a server without support for incremental delivery replying
+// with a single JSON payload is handled, and that errors are returned.
+func TestClient_QueryIncremental_singlePayload(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {"user": {"name": "Gopher"}}, "errors": [{"message": "unknown directive"}]}`)
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}})
+
+	var q struct {
+		User struct {
+			Name graphql.String
+		}
+	}
+	var calls int
+	err := client.QueryIncremental(context.Background(), &q, nil, func(payload *graphql.IncrementalPayload) error {
+		calls++
+		if payload.HasNext {
+			t.Error("got payload.HasNext: true, want: false")
+		}
+		return nil
+	})
+	if got, want := fmt.Sprint(err), "unknown directive"; got != want {
+		t.Errorf("got error: %v, want: %v", got, want)
+	}
+	if got, want := calls, 1; got != want {
+		t.Errorf("got %d calls, want: %d", got, want)
+	}
+	if got, want := q.User.Name, graphql.String("Gopher"); got != want {
+		t.Errorf("got q.User.Name: %q, want: %q", got, want)
+	}
+}
@@ -9,7 +9,11 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+
+	"github.com/InoiOy/go-graphql-client/ident"
 )
 
 // UnmarshalGraphQL parses the JSON-encoded GraphQL response data and stores
@@ -37,6 +41,140 @@ func UnmarshalGraphQL(data []byte, v interface{}) error {
 	}
 }
 
+// DecodeGraphQLResponse reads a JSON-encoded GraphQL response object from r,
+// and stores its "data" member in the GraphQL query data structure pointed to
+// by v as it's being read, without buffering it. The other members, such as
+// "errors" and "extensions", are returned as raw JSON, whatever their position
+// in the object.
+func DecodeGraphQLResponse(r io.Reader, v interface{}) (members map[string]json.RawMessage, err error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok != json.Delim('{') {
+		return nil, fmt.Errorf("invalid token '%v' at start of response object", tok)
+	}
+	members = make(map[string]json.RawMessage)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return members, err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return members, errors.New("unexpected non-key in JSON input")
+		}
+		if key != "data" {
+			var raw json.RawMessage
+			err := dec.Decode(&raw)
+			if err != nil {
+				return members, err
+			}
+			members[key] = raw
+			continue
+		}
+		err = (&decoder{tokenizer: dec}).Decode(v)
+		if err != nil {
+			return members, err
+		}
+	}
+	_, err = dec.Token() // Closing '}'.
+	return members, err
+}
+
+// UnmarshalGraphQLAt parses the JSON-encoded GraphQL response data and stores
+// the result in the part of the GraphQL query data structure pointed to by v
+// that is located at path. path is a response path, as found in errors and
+// incremental payloads: its elements are field names (string) and list indices
+// (float64 or int). A list index equal to the length of the list appends a new
+// element to it.
+func UnmarshalGraphQLAt(data []byte, v interface{}, path []interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr {
+		return fmt.Errorf("cannot decode into non-pointer %T", v)
+	}
+	target, err := valueAtPath(rv.Elem(), path)
+	if err != nil {
+		return err
+	}
+	return UnmarshalGraphQL(data, target.Addr().Interface())
+}
+
+// valueAtPath returns the value located at path within v.
+func valueAtPath(v reflect.Value, path []interface{}) (reflect.Value, error) {
+	for i, elem := range path {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem())) // v = new(T).
+			}
+			v = v.Elem()
+		}
+		switch elem := elem.(type) {
+		case string:
+			if v.Kind() != reflect.Struct {
+				return reflect.Value{}, fmt.Errorf("path %v: cannot find field %q in %v", path[:i+1], elem, v.Type())
+			}
+			f := fieldByGraphQLNameInFragments(v, elem)
+			if !f.IsValid() {
+				return reflect.Value{}, fmt.Errorf("path %v: struct field for %q doesn't exist", path[:i+1], elem)
+			}
+			v = f
+		case float64, int:
+			index := 0
+			switch elem := elem.(type) {
+			case float64:
+				index = int(elem)
+			case int:
+				index = elem
+			}
+			switch {
+			case v.Kind() == reflect.Slice && index == v.Len():
+				v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem()))) // v = append(v, T).
+			case (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && index >= 0 && index < v.Len():
+			default:
+				return reflect.Value{}, fmt.Errorf("path %v: index %d out of range of %v", path[:i+1], index, v.Type())
+			}
+			v = v.Index(index)
+		default:
+			return reflect.Value{}, fmt.Errorf("path %v: invalid element %v", path[:i+1], elem)
+		}
+	}
+	return v, nil
+}
+
+// fieldByGraphQLNameInFragments returns an exported struct field of struct v
+// that matches GraphQL name, looking into GraphQL fragments and embedded structs
+// as well, or invalid reflect.Value if none found.
+func fieldByGraphQLNameInFragments(v reflect.Value, name string) reflect.Value {
+	if f := fieldByGraphQLName(v, name); f.IsValid() {
+		return f
+	}
+	for i := 0; i < v.NumField(); i++ {
+		if !isGraphQLFragment(v.Type().Field(i)) && !v.Type().Field(i).Anonymous {
+			continue
+		}
+		f := v.Field(i)
+		if f.Kind() == reflect.Ptr {
+			if f.IsNil() {
+				if !f.CanSet() {
+					continue
+				}
+				f.Set(reflect.New(f.Type().Elem())) // f = new(T).
+			}
+			f = f.Elem()
+		}
+		if f.Kind() != reflect.Struct {
+			continue
+		}
+		if f := fieldByGraphQLNameInFragments(f, name); f.IsValid() {
+			return f
+		}
+	}
+	return reflect.Value{}
+}
+
 // decoder is a JSON decoder that performs custom unmarshaling behavior
 // for GraphQL query data structures. It's implemented on top of a JSON tokenizer.
 type decoder struct {
@@ -54,6 +192,33 @@ type decoder struct {
 	// a single JSON value into multiple GraphQL fragments or embedded structs, so
 	// we keep track of them all.
 	vs [][]reflect.Value
+
+	// Elements of keyed fields, which are written to their map once decoded,
+	// since map elements aren't addressable.
+	pendingMapWrites []mapWrite
+
+	// Stack of the objects we're in the middle of.
+	objects []object
+}
+
+// object is the state of an object being decoded.
+type object struct {
+	typename string // Value of the __typename field, if seen.
+
+	// Fragments with a type condition where the object is unmarshaled.
+	// Those that don't apply to the object's type are reset once it's decoded.
+	fragments []typedFragment
+}
+
+// typedFragment is a fragment with a type condition.
+type typedFragment struct {
+	v             reflect.Value
+	typeCondition string
+}
+
+// mapWrite is a pending write of elem to key of map m.
+type mapWrite struct {
+	m, key, elem reflect.Value
 }
 
 // Decode decodes a single JSON value from d.tokenizer into v.
@@ -63,7 +228,12 @@ func (d *decoder) Decode(v interface{}) error {
 		return fmt.Errorf("cannot decode into non-pointer %T", v)
 	}
 	d.vs = [][]reflect.Value{{rv.Elem()}}
-	return d.decode()
+	err := d.decode()
+	for _, w := range d.pendingMapWrites {
+		w.m.SetMapIndex(w.key, w.elem)
+	}
+	d.pendingMapWrites = nil
+	return err
 }
 
 // decode decodes a single JSON value from d.tokenizer into d.vs.
@@ -95,13 +265,18 @@ func (d *decoder) decode() error {
 				var f reflect.Value
 				if v.Kind() == reflect.Struct {
 					f = fieldByGraphQLName(v, key)
+					if !f.IsValid() {
+						f = d.keyedFieldElem(v, key)
+					}
 					if f.IsValid() {
 						someFieldExist = true
 					}
 				}
 				d.vs[i] = append(d.vs[i], f)
 			}
-			if !someFieldExist {
+			// __typename is requested for fragments with type conditions and
+			// interfaces, whether or not there's a field for it.
+			if !someFieldExist && key != "__typename" {
 				return fmt.Errorf("struct field for %q doesn't exist in any of %v places to unmarshal", key, len(d.vs))
 			}
 
@@ -113,6 +288,9 @@ func (d *decoder) decode() error {
 			} else if err != nil {
 				return err
 			}
+			if typename, ok := tok.(string); ok && key == "__typename" {
+				d.objects[len(d.objects)-1].typename = typename
+			}
 
 		// Are we inside an array and seeing next value (rather than end of array)?
 		case d.state() == '[' && tok != json.Delim(']'):
@@ -135,6 +313,17 @@ func (d *decoder) decode() error {
 			}
 		}
 
+		if d.polymorphic() {
+			// Decode the value into interfaces by the type named by its
+			// __typename, and elsewhere as usual.
+			err := d.decodePolymorphic(tok)
+			if err != nil {
+				return err
+			}
+			d.popAllVs()
+			continue
+		}
+
 		switch tok := tok.(type) {
 		case string, json.Number, bool, nil:
 			// Value.
@@ -157,6 +346,7 @@ func (d *decoder) decode() error {
 				// Start of object.
 
 				d.pushState(tok)
+				d.objects = append(d.objects, object{})
 
 				frontier := make([]reflect.Value, len(d.vs)) // Places to look for GraphQL fragments/embedded structs.
 				for i := range d.vs {
@@ -183,6 +373,10 @@ func (d *decoder) decode() error {
 							// Add GraphQL fragment or embedded struct.
 							d.vs = append(d.vs, []reflect.Value{v.Field(i)})
 							frontier = append(frontier, v.Field(i))
+							if typeCondition := TypeCondition(v.Type().Field(i)); typeCondition != "" {
+								o := &d.objects[len(d.objects)-1]
+								o.fragments = append(o.fragments, typedFragment{v: v.Field(i), typeCondition: typeCondition})
+							}
 						}
 					}
 				}
@@ -209,6 +403,9 @@ func (d *decoder) decode() error {
 				}
 			case '}', ']':
 				// End of object or array.
+				if tok == '}' {
+					d.endObject()
+				}
 				d.popAllVs()
 				d.popState()
 			default:
@@ -221,6 +418,129 @@ func (d *decoder) decode() error {
 	return nil
 }
 
+// endObject pops the object that ends off the stack, and resets the
+// fragments that don't apply to it, if its __typename is known.
+func (d *decoder) endObject() {
+	o := d.objects[len(d.objects)-1]
+	d.objects = d.objects[:len(d.objects)-1]
+	if o.typename == "" {
+		return
+	}
+	for _, f := range o.fragments {
+		if !FragmentApplies(f.typeCondition, o.typename) && f.v.CanSet() {
+			f.v.Set(reflect.Zero(f.v.Type()))
+		}
+	}
+}
+
+// polymorphic reports whether the next JSON value is unmarshaled into
+// a non-empty interface in any of d.vs.
+func (d *decoder) polymorphic() bool {
+	for i := range d.vs {
+		v := d.vs[i][len(d.vs[i])-1]
+		if v.Kind() == reflect.Interface && v.NumMethod() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// decodePolymorphic reads the JSON value starting with tok, and unmarshals it
+// into the top of each d.vs stack. Non-empty interfaces are set to a value
+// of the type registered for the GraphQL type named by its __typename field.
+func (d *decoder) decodePolymorphic(tok json.Token) error {
+	value, err := d.readValue(tok)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	for i := range d.vs {
+		v := d.vs[i][len(d.vs[i])-1]
+		switch {
+		case !v.IsValid():
+		case v.Kind() == reflect.Interface && v.NumMethod() > 0:
+			if value == nil {
+				v.Set(reflect.Zero(v.Type()))
+				continue
+			}
+			obj, _ := value.(map[string]interface{})
+			typename, _ := obj["__typename"].(string)
+			t, ok := implementation(v.Type(), typename)
+			if !ok {
+				return fmt.Errorf("no type implementing %v is registered for GraphQL type %q", v.Type(), typename)
+			}
+			p := reflect.New(t)
+			if err := UnmarshalGraphQL(data, p.Interface()); err != nil {
+				return err
+			}
+			if t.Implements(v.Type()) {
+				v.Set(p.Elem())
+			} else {
+				v.Set(p)
+			}
+		default:
+			if err := UnmarshalGraphQL(data, v.Addr().Interface()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readValue reads the rest of the JSON value starting with tok.
+// Objects are read as map[string]interface{}, arrays as []interface{},
+// and numbers as json.Number.
+func (d *decoder) readValue(tok json.Token) (interface{}, error) {
+	switch tok {
+	case json.Delim('{'):
+		obj := make(map[string]interface{})
+		for {
+			tok, err := d.tokenizer.Token()
+			if err != nil {
+				return nil, err
+			}
+			if tok == json.Delim('}') {
+				return obj, nil
+			}
+			key, ok := tok.(string)
+			if !ok {
+				return nil, errors.New("unexpected non-key in JSON input")
+			}
+			tok, err = d.tokenizer.Token()
+			if err != nil {
+				return nil, err
+			}
+			obj[key], err = d.readValue(tok)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case json.Delim('['):
+		arr := []interface{}{}
+		for {
+			tok, err := d.tokenizer.Token()
+			if err != nil {
+				return nil, err
+			}
+			if tok == json.Delim(']') {
+				return arr, nil
+			}
+			elem, err := d.readValue(tok)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, elem)
+		}
+	case json.Delim('}'), json.Delim(']'):
+		return nil, errors.New("unexpected delimiter in JSON input")
+	default:
+		return tok, nil
+	}
+}
+
 // pushState pushes a new parse state s onto the stack.
 func (d *decoder) pushState(s json.Delim) {
 	d.parseState = append(d.parseState, s)
@@ -267,6 +587,103 @@ func fieldByGraphQLName(v reflect.Value, name string) reflect.Value {
 	return reflect.Value{}
 }
 
+// keyedFieldElem returns the element of a keyed field of struct v whose
+// alias is key, e.g. "repositories_1", or invalid reflect.Value if none found.
+// The element is written to the map at the end of decoding.
+func (d *decoder) keyedFieldElem(v reflect.Value, key string) reflect.Value {
+	i := strings.LastIndex(key, "_")
+	if i == -1 {
+		return reflect.Value{}
+	}
+	index, err := strconv.Atoi(key[i+1:])
+	if err != nil || index < 0 {
+		return reflect.Value{}
+	}
+	for j := 0; j < v.NumField(); j++ {
+		f := v.Type().Field(j)
+		if prefix, ok := KeyedField(f); !ok || prefix != key[:i] || f.PkgPath != "" {
+			continue
+		}
+		m := v.Field(j)
+		if m.Kind() == reflect.Ptr {
+			m = m.Elem()
+		}
+		if m.Kind() != reflect.Map || index >= m.Len() {
+			return reflect.Value{}
+		}
+		k := SortedMapKeys(m)[index]
+		elem := reflect.New(m.Type().Elem()).Elem()
+		elem.Set(m.MapIndex(k))
+		d.pendingMapWrites = append(d.pendingMapWrites, mapWrite{m: m, key: k, elem: elem})
+		return elem
+	}
+	return reflect.Value{}
+}
+
+// KeyedField reports whether struct field f is a keyed field: a map whose
+// graphql tag contains the $key placeholder, e.g. `repository(name: $key)`.
+// A keyed field is queried once per key of the map, in the order of
+// SortedMapKeys, with the key substituted for $key. The field of the i-th
+// key is aliased prefix_i, where prefix is the alias in the tag, if any,
+// or else the lowerCamelCase field name.
+func KeyedField(f reflect.StructField) (prefix string, ok bool) {
+	value, ok := f.Tag.Lookup("graphql")
+	if !ok || !HasKeyPlaceholder(value) {
+		return "", false
+	}
+	t := f.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Map {
+		return "", false
+	}
+	value = strings.TrimSpace(value)
+	if i := strings.IndexAny(value, ":(@"); i != -1 && value[i] == ':' {
+		return strings.TrimSpace(value[:i]), true
+	}
+	return ident.ParseMixedCaps(f.Name).ToLowerCamelCase(), true
+}
+
+// HasKeyPlaceholder reports whether graphql struct tag value
+// references the $key placeholder of keyed fields.
+func HasKeyPlaceholder(value string) bool {
+	for {
+		i := strings.Index(value, "$key")
+		if i == -1 {
+			return false
+		}
+		value = value[i+len("$key"):]
+		if value == "" || !isNameChar(value[0]) {
+			return true
+		}
+	}
+}
+
+// SortedMapKeys returns the keys of map v, sorted by value
+// for string and integer keys, and by their formatted value otherwise.
+func SortedMapKeys(v reflect.Value) []reflect.Value {
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		switch a.Kind() {
+		case reflect.String:
+			return a.String() < b.String()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return a.Int() < b.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return a.Uint() < b.Uint()
+		default:
+			return fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface())
+		}
+	})
+	return keys
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
 // hasGraphQLName reports whether struct field f has GraphQL name.
 func hasGraphQLName(f reflect.StructField, name string) bool {
 	value, ok := f.Tag.Lookup("graphql")
@@ -283,6 +700,10 @@ func hasGraphQLName(f reflect.StructField, name string) bool {
 	if i := strings.Index(value, "("); i != -1 {
 		value = value[:i]
 	}
+	if i := strings.Index(value, "@"); i != -1 {
+		// Directives, e.g. "friends @stream(initialCount: 1)".
+		value = value[:i]
+	}
 	if i := strings.Index(value, ":"); i != -1 {
 		value = value[:i]
 	}
@@ -1,7 +1,9 @@
 package jsonutil_test
 
 import (
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -314,18 +316,196 @@ func TestUnmarshalGraphQL_union(t *testing.T) {
 			},
 			CreatedAt: time.Unix(1498709521, 0).UTC(),
 		},
-		ReopenedEvent: reopenedEvent{
-			Actor: actor{
-				Login: "shurcooL-test",
-			},
-			CreatedAt: time.Unix(1498709521, 0).UTC(),
-		},
+		// Zero, since the fragment doesn't apply to a ClosedEvent.
+		ReopenedEvent: reopenedEvent{},
 	}
 	if !reflect.DeepEqual(got, want) {
 		t.Error("not equal")
 	}
 }
 
+func TestUnmarshalGraphQL_unionPossibleTypes(t *testing.T) {
+	/*
+		{
+			nodes {
+				... on Character {
+					name
+				}
+				... on Starship {
+					length
+				}
+				__typename
+			}
+		}
+	*/
+	jsonutil.RegisterPossibleTypes("Character", "Human", "Droid")
+	type query struct {
+		Nodes []struct {
+			Character struct{ Name string } `graphql:"... on Character"`
+			Starship  struct{ Length int }  `graphql:"... on Starship"`
+			Typename  string                `graphql:"__typename"`
+		}
+	}
+	var got query
+	err := jsonutil.UnmarshalGraphQL([]byte(`{
+		"nodes": [
+			{"name": "R2-D2", "__typename": "Droid"},
+			{"length": 34, "__typename": "Starship"},
+			{"name": "Luke Skywalker"}
+		]
+	}`), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want query
+	want.Nodes = make([]struct {
+		Character struct{ Name string } `graphql:"... on Character"`
+		Starship  struct{ Length int }  `graphql:"... on Starship"`
+		Typename  string                `graphql:"__typename"`
+	}, 3)
+	want.Nodes[0].Character.Name = "R2-D2"
+	want.Nodes[0].Typename = "Droid"
+	want.Nodes[1].Starship.Length = 34
+	want.Nodes[1].Typename = "Starship"
+	// Unknown __typename, so all fragments are populated.
+	want.Nodes[2].Character.Name = "Luke Skywalker"
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("not equal:\ngot: %v\nwant: %v", got, want)
+	}
+}
+
+type nodeFields struct {
+	ID string
+}
+
+func (nodeFields) GetGraphQLFragment() (string, string) { return "NodeFields", "Node" }
+
+// Test that fragments on interfaces apply to their registered possible types
+// only.
+func TestUnmarshalGraphQL_interfacePossibleTypes(t *testing.T) {
+	/*
+		{
+			repositoryOwner {
+				__typename
+				... on Actor {
+					login
+				}
+				... on Starrable {
+					stargazerCount
+				}
+				...NodeFields
+			}
+		}
+	*/
+	jsonutil.RegisterPossibleTypes("Actor", "User", "Bot")
+	jsonutil.RegisterPossibleTypes("Node", "User", "Repository")
+	type query struct {
+		RepositoryOwner struct {
+			Actor     struct{ Login string }       `graphql:"... on Actor"`
+			Starrable struct{ StargazerCount int } `graphql:"... on Starrable"`
+			nodeFields
+		}
+	}
+	var got query
+	err := jsonutil.UnmarshalGraphQL([]byte(`{
+		"repositoryOwner": {"__typename": "User", "login": "gopher", "stargazerCount": 1, "id": "1"}
+	}`), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want query
+	want.RepositoryOwner.Actor.Login = "gopher"
+	want.RepositoryOwner.ID = "1"
+	// Starrable is left zero, since User isn't registered as one of its
+	// possible types.
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("not equal:\ngot: %+v\nwant: %+v", got, want)
+	}
+}
+
+type character interface {
+	characterName() string
+}
+
+type human struct {
+	Name   string
+	Height float64
+}
+
+func (h human) characterName() string { return h.Name }
+
+type droid struct {
+	Name            string
+	PrimaryFunction string
+}
+
+func (d *droid) characterName() string { return d.Name }
+
+func TestUnmarshalGraphQL_interface(t *testing.T) {
+	/*
+		{
+			hero {
+				__typename
+				... on Droid {
+					name
+					primaryFunction
+				}
+				... on Human {
+					name
+					height
+				}
+			}
+			characters {
+				...
+			}
+		}
+	*/
+	jsonutil.RegisterType("Human", reflect.TypeOf(human{}))
+	jsonutil.RegisterType("Droid", reflect.TypeOf(droid{}))
+	type query struct {
+		Hero       character
+		Characters []character
+		Villain    character
+	}
+	var got query
+	err := jsonutil.UnmarshalGraphQL([]byte(`{
+		"hero": {"__typename": "Droid", "name": "R2-D2", "primaryFunction": "Astromech"},
+		"characters": [
+			{"__typename": "Human", "name": "Luke Skywalker", "height": 1.72},
+			{"__typename": "Droid", "name": "C-3PO", "primaryFunction": "Protocol"}
+		],
+		"villain": null
+	}`), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := query{
+		Hero: &droid{Name: "R2-D2", PrimaryFunction: "Astromech"},
+		Characters: []character{
+			human{Name: "Luke Skywalker", Height: 1.72},
+			&droid{Name: "C-3PO", PrimaryFunction: "Protocol"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("not equal:\ngot: %#v\nwant: %#v", got, want)
+	}
+}
+
+func TestUnmarshalGraphQL_interfaceUnregisteredType(t *testing.T) {
+	type query struct {
+		Hero character
+	}
+	err := jsonutil.UnmarshalGraphQL([]byte(`{
+		"hero": {"__typename": "Wookiee", "name": "Chewbacca"}
+	}`), new(query))
+	if err == nil {
+		t.Fatal("got error: nil, want: non-nil")
+	}
+	if got, want := err.Error(), `no type implementing jsonutil_test.character is registered for GraphQL type "Wookiee"`; got != want {
+		t.Errorf("got error: %v, want: %v", got, want)
+	}
+}
+
 // Issue https://github.com/shurcooL/githubv4/issues/18.
 func TestUnmarshalGraphQL_arrayInsideInlineFragment(t *testing.T) {
 	/*
@@ -393,3 +573,145 @@ func TestUnmarshalGraphQL_arrayInsideInlineFragment(t *testing.T) {
 		t.Error("not equal")
 	}
 }
+
+func TestUnmarshalGraphQL_directives(t *testing.T) {
+	type query struct {
+		User struct {
+			Login   string
+			Email   *string `graphql:"email @include(if: $withEmail)"`
+			Avatar  string  `graphql:"small: avatarUrl(size: 16) @skip(if: $large)"`
+			Company *string `graphql:"company @include(if: $withCompany)"`
+		}
+	}
+	var got query
+	err := jsonutil.UnmarshalGraphQL([]byte(`{
+		"user": {
+			"login": "gopher",
+			"email": "gopher@example.com",
+			"small": "https://example.com/gopher.png"
+		}
+	}`), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want query
+	want.User.Login = "gopher"
+	want.User.Email = new(string)
+	*want.User.Email = "gopher@example.com"
+	want.User.Avatar = "https://example.com/gopher.png"
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("not equal: %+v", got)
+	}
+}
+
+func TestUnmarshalGraphQL_keyedFields(t *testing.T) {
+	type repository struct {
+		Stars int `graphql:"stargazerCount"`
+	}
+	got := struct {
+		Repositories map[string]*repository `graphql:"repository(owner: $owner, name: $key)"`
+		Users        *map[int]string        `graphql:"u: user(id: $key)"`
+	}{
+		Repositories: map[string]*repository{"tools": nil, "go": nil, "net": {Stars: 1}},
+		Users:        &map[int]string{10: "", 9: ""},
+	}
+	err := jsonutil.UnmarshalGraphQL([]byte(`{
+		"repositories_0": {"stargazerCount": 120000},
+		"repositories_1": null,
+		"repositories_2": {"stargazerCount": 7000},
+		"u_0": "nine",
+		"u_1": "ten"
+	}`), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]*repository{"go": {Stars: 120000}, "net": nil, "tools": {Stars: 7000}}; !reflect.DeepEqual(got.Repositories, want) {
+		t.Errorf("got repositories: %+v, want: %+v", got.Repositories, want)
+	}
+	if want := map[int]string{9: "nine", 10: "ten"}; !reflect.DeepEqual(*got.Users, want) {
+		t.Errorf("got users: %v, want: %v", *got.Users, want)
+	}
+
+	err = jsonutil.UnmarshalGraphQL([]byte(`{"repositories_3": null}`), &got)
+	if got, want := fmt.Sprint(err), `struct field for "repositories_3" doesn't exist in any of 1 places to unmarshal`; got != want {
+		t.Errorf("got error: %v, want: %v", got, want)
+	}
+}
+
+func TestSortedMapKeys(t *testing.T) {
+	var got []interface{}
+	for _, k := range jsonutil.SortedMapKeys(reflect.ValueOf(map[int]bool{10: true, -1: true, 2: true})) {
+		got = append(got, k.Interface())
+	}
+	if want := []interface{}{-1, 2, 10}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got keys: %v, want: %v", got, want)
+	}
+}
+
+func TestUnmarshalGraphQLAt(t *testing.T) {
+	type query struct {
+		User struct {
+			Name     string
+			Fragment struct {
+				Bio string
+			} `graphql:"... @defer"`
+			Friends []struct {
+				Name string
+			} `graphql:"friends @stream(initialCount: 1)"`
+		}
+	}
+	var got query
+	err := jsonutil.UnmarshalGraphQL([]byte(`{"user": {"name": "Gopher", "friends": [{"name": "Alice"}]}}`), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = jsonutil.UnmarshalGraphQLAt([]byte(`{"bio": "Go"}`), &got, []interface{}{"user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = jsonutil.UnmarshalGraphQLAt([]byte(`{"name": "Bob"}`), &got, []interface{}{"user", "friends", float64(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = jsonutil.UnmarshalGraphQLAt([]byte(`{"name": "Eve"}`), &got, []interface{}{"user", "friends", float64(5)})
+	if err == nil {
+		t.Error("got error: nil, want: non-nil")
+	}
+	var want query
+	want.User.Name = "Gopher"
+	want.User.Fragment.Bio = "Go"
+	want.User.Friends = []struct {
+		Name string
+	}{{"Alice"}, {"Bob"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("not equal: %+v", got)
+	}
+}
+
+func TestDecodeGraphQLResponse(t *testing.T) {
+	var got struct {
+		Me struct {
+			Name string
+		}
+	}
+	members, err := jsonutil.DecodeGraphQLResponse(strings.NewReader(`{
+		"errors": [{"message": "partial"}],
+		"data": {"me": {"name": "Luke Skywalker"}},
+		"extensions": {"cost": 1}
+	}`), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Me.Name != "Luke Skywalker" {
+		t.Errorf("got.Me.Name: %q", got.Me.Name)
+	}
+	if got, want := string(members["errors"]), `[{"message": "partial"}]`; got != want {
+		t.Errorf("got errors: %s, want: %s", got, want)
+	}
+	if got, want := string(members["extensions"]), `{"cost": 1}`; got != want {
+		t.Errorf("got extensions: %s, want: %s", got, want)
+	}
+	if _, ok := members["data"]; ok {
+		t.Error("got data member, want it to be decoded")
+	}
+}
@@ -0,0 +1,216 @@
+package jsonutil
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// registry holds the Go types of GraphQL object types, for decoding into
+// interface values, and the possible types of GraphQL abstract types,
+// for matching type conditions. It's process-wide: the registered types
+// are shared by all decoders, and thus by all clients.
+var registry = struct {
+	sync.RWMutex
+	types    map[string]reflect.Type    // GraphQL type name -> Go struct type.
+	possible map[string]map[string]bool // Abstract type name -> possible type names.
+}{
+	types:    make(map[string]reflect.Type),
+	possible: make(map[string]map[string]bool),
+}
+
+// RegisterType registers Go struct type t (or pointer to struct) as
+// the type of objects of GraphQL type typename.
+func RegisterType(typename string, t reflect.Type) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	registry.Lock()
+	registry.types[typename] = t
+	registry.Unlock()
+}
+
+// RegisterPossibleTypes registers the possible types of abstract GraphQL
+// type, an interface or union, so that fragments on it match objects
+// of those types.
+func RegisterPossibleTypes(abstract string, possible ...string) {
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.possible[abstract] == nil {
+		registry.possible[abstract] = make(map[string]bool)
+	}
+	for _, typename := range possible {
+		registry.possible[abstract][typename] = true
+	}
+}
+
+// FragmentApplies reports whether a fragment with type condition
+// typeCondition applies to an object of GraphQL type typename: whether
+// typeCondition is typename, or an abstract type that typename is
+// registered as a possible type of.
+func FragmentApplies(typeCondition, typename string) bool {
+	if typeCondition == typename {
+		return true
+	}
+	registry.RLock()
+	defer registry.RUnlock()
+	return registry.possible[typeCondition][typename]
+}
+
+// Implementation is a registered Go type implementing an interface.
+type Implementation struct {
+	Typename string       // GraphQL type name.
+	Type     reflect.Type // Go struct type. It, or a pointer to it, implements the interface.
+}
+
+// Implementations returns the registered types implementing Go interface
+// type iface, sorted by GraphQL type name.
+func Implementations(iface reflect.Type) []Implementation {
+	if iface.Kind() != reflect.Interface || iface.NumMethod() == 0 {
+		return nil
+	}
+	registry.RLock()
+	var impls []Implementation
+	for typename, t := range registry.types {
+		if t.Implements(iface) || reflect.PtrTo(t).Implements(iface) {
+			impls = append(impls, Implementation{Typename: typename, Type: t})
+		}
+	}
+	registry.RUnlock()
+	sort.Slice(impls, func(i, j int) bool { return impls[i].Typename < impls[j].Typename })
+	return impls
+}
+
+// implementation returns the registered type of GraphQL type typename,
+// if it implements Go interface type iface.
+func implementation(iface reflect.Type, typename string) (reflect.Type, bool) {
+	registry.RLock()
+	t, ok := registry.types[typename]
+	registry.RUnlock()
+	if !ok || !(t.Implements(iface) || reflect.PtrTo(t).Implements(iface)) {
+		return nil, false
+	}
+	return t, true
+}
+
+// fragment is implemented by named fragment types. It's the same as
+// GraphQLFragment of the graphql package.
+type fragment interface {
+	GetGraphQLFragment() (name, typeCondition string)
+}
+
+var fragmentInterface = reflect.TypeOf((*fragment)(nil)).Elem()
+
+// FragmentOf returns the name and type condition of struct t,
+// if it's a named fragment. A struct embedding a named fragment isn't one
+// itself, unless it declares its own GetGraphQLFragment method.
+func FragmentOf(t reflect.Type) (name, typeCondition string, ok bool) {
+	name, typeCondition, ok = fragmentMethod(t)
+	if !ok {
+		return "", "", false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.Anonymous {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if n, tc, ok := fragmentMethod(ft); ok && n == name && tc == typeCondition {
+				// Method promoted from the embedded fragment.
+				return "", "", false
+			}
+		}
+	}
+	return name, typeCondition, true
+}
+
+// fragmentMethod calls the GetGraphQLFragment method of type t, if any.
+func fragmentMethod(t reflect.Type) (name, typeCondition string, ok bool) {
+	switch {
+	case t.Kind() != reflect.Struct:
+		return "", "", false
+	case t.Implements(fragmentInterface):
+		name, typeCondition = reflect.Zero(t).Interface().(fragment).GetGraphQLFragment()
+	case reflect.PtrTo(t).Implements(fragmentInterface):
+		name, typeCondition = reflect.New(t).Interface().(fragment).GetGraphQLFragment()
+	default:
+		return "", "", false
+	}
+	return name, typeCondition, true
+}
+
+// NamedSpread returns the name of the fragment spread by graphql struct tag
+// value, e.g. "UserFields" for "...UserFields @include(if: $withUser)".
+// It returns false for inline fragments, e.g. "... on User".
+func NamedSpread(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "...") {
+		return "", false
+	}
+	value = strings.TrimSpace(strings.TrimPrefix(value, "..."))
+	i := 0
+	for i < len(value) && isNameChar(value[i]) {
+		i++
+	}
+	if name := value[:i]; name != "" && name != "on" {
+		return name, true
+	}
+	return "", false
+}
+
+// TypeCondition returns the type condition of struct field f, if it's
+// a fragment with one: an inline fragment, e.g. `graphql:"... on User"`,
+// a spread of a named fragment, or an embedded named fragment.
+func TypeCondition(f reflect.StructField) string {
+	value, ok := f.Tag.Lookup("graphql")
+	t := f.Type
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if _, spread := NamedSpread(value); (!ok && f.Anonymous) || (ok && spread) {
+		_, typeCondition, _ := FragmentOf(t)
+		return typeCondition
+	}
+	value = strings.TrimSpace(value)
+	if !ok || !strings.HasPrefix(value, "...") {
+		return ""
+	}
+	value = strings.TrimSpace(strings.TrimPrefix(value, "..."))
+	if !strings.HasPrefix(value, "on ") {
+		return "" // E.g., "... @defer".
+	}
+	value = strings.TrimSpace(strings.TrimPrefix(value, "on "))
+	i := 0
+	for i < len(value) && isNameChar(value[i]) {
+		i++
+	}
+	return value[:i]
+}
+
+// SelectsTypename reports whether struct t selects fragments with a type
+// condition, and whether it selects the __typename field, looking into
+// embedded structs and fragments without type condition, which are merged
+// into t.
+func SelectsTypename(t reflect.Type) (typeConditions, typename bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		value, ok := f.Tag.Lookup("graphql")
+		switch {
+		case ok && strings.TrimSpace(value) == "__typename":
+			typename = true
+		case TypeCondition(f) != "":
+			typeConditions = true
+		case (!ok && f.Anonymous) || (ok && strings.HasPrefix(strings.TrimSpace(value), "...")):
+			ft := f.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				tc, tn := SelectsTypename(ft)
+				typeConditions, typename = typeConditions || tc, typename || tn
+			}
+		}
+	}
+	return typeConditions, typename
+}
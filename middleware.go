@@ -0,0 +1,44 @@
+package graphql
+
+import "context"
+
+// Handler sends a GraphQL request and returns the decoded response.
+type Handler func(ctx context.Context, req *Request) (*Response, error)
+
+// Middleware intercepts GraphQL operations sent by Client.
+// It receives the next Handler in the chain and returns a Handler that
+// typically inspects or modifies the request, calls next, and then
+// inspects or modifies the response.
+//
+// Middleware sees the operation itself (query, variables, operation name
+// and type), which an http.RoundTripper can't. It can be used to add
+// authentication headers, logging, metrics, variable redaction or
+// request rewriting.
+//
+// Middlewares always see the raw response data in Response.Data, and may
+// rewrite it: Query, Mutate and their named variants decode the data
+// returned by the outermost middleware. Without middlewares, the data is
+// decoded directly into the query struct while it's read instead.
+type Middleware func(next Handler) Handler
+
+// WithMiddleware appends middlewares to the chain of c.
+// Middlewares run in the order they were added: the first one
+// sees the request first and the response last.
+func (c *Client) WithMiddleware(middlewares ...Middleware) *Client {
+	c.middlewares = append(c.middlewares, middlewares...)
+	return c
+}
+
+// chain wraps h with middlewares, the first middleware being the outermost.
+func chain(middlewares []Middleware, h Handler) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// handler wraps h with tracing if enabled, and with the middlewares of c,
+// so that operations are traced as the middlewares sent them.
+func (c *Client) handler(h Handler) Handler {
+	return chain(c.middlewares, c.traced(h))
+}
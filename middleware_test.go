@@ -0,0 +1,140 @@
+package graphql_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/InoiOy/go-graphql-client"
+)
+
+func TestClient_WithMiddleware(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		if got, want := req.Header.Get("Authorization"), "Bearer token"; got != want {
+			t.Errorf("got Authorization header: %q, want: %q", got, want)
+		}
+		body := mustRead(req.Body)
+		if got, want := body, `{"query":"query GetUser($id:ID!){user(id: $id){name}}","variables":{"id":"rewritten"},"operationName":"GetUser"}`+"\n"; got != want {
+			t.Errorf("got body: %v, want %v", got, want)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {"user": {"name": "Gopher"}}, "extensions": {"cost": 1}}`)
+	})
+
+	var calls []string
+	logger := func(next graphql.Handler) graphql.Handler {
+		return func(ctx context.Context, req *graphql.Request) (*graphql.Response, error) {
+			calls = append(calls, "logger:"+req.Type.String()+":"+req.OperationName)
+			resp, err := next(ctx, req)
+			if resp != nil {
+				calls = append(calls, "logger:cost="+fmtValue(resp.Extensions["cost"]))
+			}
+			return resp, err
+		}
+	}
+	auth := func(next graphql.Handler) graphql.Handler {
+		return func(ctx context.Context, req *graphql.Request) (*graphql.Response, error) {
+			calls = append(calls, "auth")
+			req.Header.Set("Authorization", "Bearer token")
+			req.Variables = map[string]interface{}{"id": graphql.ID("rewritten")}
+			resp, err := next(ctx, req)
+			calls = append(calls, "auth:done")
+			return resp, err
+		}
+	}
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}}).
+		WithMiddleware(logger, auth)
+
+	var q struct {
+		User struct {
+			Name graphql.String
+		} `graphql:"user(id: $id)"`
+	}
+	err := client.NamedQuery(context.Background(), "GetUser", &q, map[string]interface{}{"id": graphql.Variable{Value: "original", Type: "ID!"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := q.User.Name, graphql.String("Gopher"); got != want {
+		t.Errorf("got q.User.Name: %q, want: %q", got, want)
+	}
+	want := []string{"logger:query:GetUser", "auth", "auth:done", "logger:cost=1"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("got calls: %v, want: %v", calls, want)
+	}
+}
+
+func TestClient_WithMiddleware_shortCircuit(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		t.Error("request unexpectedly reached the server")
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}}).
+		WithMiddleware(func(next graphql.Handler) graphql.Handler {
+			return func(ctx context.Context, req *graphql.Request) (*graphql.Response, error) {
+				data := json.RawMessage(`{"user": {"name": "Cached"}}`)
+				return &graphql.Response{Data: &data}, nil
+			}
+		})
+
+	var q struct {
+		User struct {
+			Name graphql.String
+		}
+	}
+	err := client.Query(context.Background(), &q, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := q.User.Name, graphql.String("Cached"); got != want {
+		t.Errorf("got q.User.Name: %q, want: %q", got, want)
+	}
+}
+
+// Test that middlewares see the response data of Query, and that the data
+// they return is the one decoded into the query struct.
+func TestClient_WithMiddleware_rewriteData(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {"user": {"name": "Gopher"}}}`)
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}}).
+		WithMiddleware(func(next graphql.Handler) graphql.Handler {
+			return func(ctx context.Context, req *graphql.Request) (*graphql.Response, error) {
+				resp, err := next(ctx, req)
+				if err != nil {
+					return resp, err
+				}
+				if resp.Data == nil {
+					t.Fatal("got resp.Data: nil, want: non-nil")
+				}
+				if got, want := string(*resp.Data), `{"user": {"name": "Gopher"}}`; got != want {
+					t.Errorf("got resp.Data: %s, want: %s", got, want)
+				}
+				data := json.RawMessage(`{"user": {"name": "Rewritten"}}`)
+				resp.Data = &data
+				return resp, nil
+			}
+		})
+
+	var q struct {
+		User struct {
+			Name graphql.String
+		}
+	}
+	err := client.Query(context.Background(), &q, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := q.User.Name, graphql.String("Rewritten"); got != want {
+		t.Errorf("got q.User.Name: %q, want: %q", got, want)
+	}
+}
+
+func fmtValue(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,342 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/InoiOy/go-graphql-client/ident"
+)
+
+// PaginationOptions configures a Paginator.
+type PaginationOptions struct {
+	// OperationName is the name of the query operation, if any.
+	OperationName string
+
+	// Connection is the path of the Relay connection in the query, as
+	// dot-separated GraphQL field names (or aliases), e.g. "repository.issues".
+	// By default, the first connection found in the query is used. A connection
+	// is an object selecting pageInfo { hasNextPage endCursor }.
+	Connection string
+
+	// CursorVariable is the name of the variable holding the cursor of
+	// the page to fetch. Defaults to "cursor". If it's not in the initial
+	// variables, it's declared as a nullable String starting at null.
+	CursorVariable string
+
+	// PageSizeVariable is the name of the variable holding the number of
+	// nodes per page. Defaults to "first".
+	PageSizeVariable string
+
+	// PageSize, if positive, is set as the PageSizeVariable variable.
+	PageSize int
+
+	// MaxPages, if positive, is the maximum number of pages to fetch.
+	MaxPages int
+}
+
+// Paginator runs a query over the pages of a Relay connection, advancing
+// the cursor variable from one page to the next.
+//
+// Its use follows the pattern of bufio.Scanner:
+//
+//	p := client.Paginate(&q, variables, graphql.PaginationOptions{PageSize: 100})
+//	for p.Next(ctx) {
+//		// q holds the current page.
+//	}
+//	if err := p.Err(); err != nil {
+//		// Handle error.
+//	}
+type Paginator struct {
+	client    *Client
+	q         interface{}
+	variables map[string]interface{}
+	opts      PaginationOptions
+
+	pages int
+	done  bool
+	err   error
+}
+
+// Paginate returns a Paginator running query q, a pointer to struct
+// containing a Relay connection, with variables. variables is copied,
+// and isn't modified. An error converting variables is reported by Err.
+func (c *Client) Paginate(q interface{}, variables interface{}, opts PaginationOptions) *Paginator {
+	if opts.CursorVariable == "" {
+		opts.CursorVariable = "cursor"
+	}
+	if opts.PageSizeVariable == "" {
+		opts.PageSizeVariable = "first"
+	}
+	m, err := variablesMap(variables)
+	vars := make(map[string]interface{}, len(m)+2)
+	for k, v := range m {
+		vars[k] = v
+	}
+	if _, ok := vars[opts.CursorVariable]; !ok {
+		vars[opts.CursorVariable] = (*String)(nil)
+	}
+	if opts.PageSize > 0 {
+		vars[opts.PageSizeVariable] = Int(opts.PageSize)
+	}
+	return &Paginator{client: c, q: q, variables: vars, opts: opts, err: err}
+}
+
+// Next fetches the next page into the query struct. It returns false when
+// there are no more pages, MaxPages have been fetched, ctx is done or an
+// error occurred, which is then reported by Err.
+func (p *Paginator) Next(ctx context.Context) bool {
+	if p.done || p.err != nil {
+		return false
+	}
+	if p.opts.MaxPages > 0 && p.pages >= p.opts.MaxPages {
+		p.done = true
+		return false
+	}
+	if err := ctx.Err(); err != nil {
+		p.err = err
+		return false
+	}
+	if err := p.client.NamedQuery(ctx, p.opts.OperationName, p.q, p.variables); err != nil {
+		p.err = err
+		return false
+	}
+	p.pages++
+	conn, err := p.connection()
+	if err != nil {
+		p.err = err
+		return false
+	}
+	hasNextPage, endCursor, err := pageInfoOf(conn)
+	if err != nil {
+		p.err = err
+		return false
+	}
+	if !hasNextPage {
+		p.done = true
+		return true
+	}
+	cursor := p.variables[p.opts.CursorVariable]
+	if prev, ok := stringOf(reflect.ValueOf(variableValue(cursor))); ok && prev == endCursor {
+		p.err = fmt.Errorf("pagination cursor didn't advance from %q", endCursor)
+		return true
+	}
+	p.variables[p.opts.CursorVariable] = cursorValue(cursor, endCursor)
+	return true
+}
+
+// Err returns the first error encountered by Next, if any.
+func (p *Paginator) Err() error {
+	return p.err
+}
+
+// Pages returns the number of pages fetched so far.
+func (p *Paginator) Pages() int {
+	return p.pages
+}
+
+// EachNode fetches the remaining pages and calls fn for each node of
+// the connection, in order. node is a pointer to the element of the
+// connection's nodes, or to the node of its edges. It's only valid until
+// fn returns. If fn returns an error, EachNode stops and returns it.
+func (p *Paginator) EachNode(ctx context.Context, fn func(node interface{}) error) error {
+	for p.Next(ctx) {
+		conn, err := p.connection()
+		if err != nil {
+			return err
+		}
+		nodes, err := nodesOf(conn)
+		if err != nil {
+			return err
+		}
+		for _, node := range nodes {
+			if err := fn(node); err != nil {
+				return err
+			}
+		}
+	}
+	return p.Err()
+}
+
+// connection returns the connection struct in the query struct.
+func (p *Paginator) connection() (reflect.Value, error) {
+	v := reflect.ValueOf(p.q)
+	if p.opts.Connection == "" {
+		if conn, ok := findConnection(v); ok {
+			return conn, nil
+		}
+		return reflect.Value{}, errors.New("no Relay connection with pageInfo { hasNextPage endCursor } in query")
+	}
+	for _, name := range strings.Split(p.opts.Connection, ".") {
+		v = indirect(v)
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, fmt.Errorf("connection %q: %q isn't an object", p.opts.Connection, name)
+		}
+		f, ok := graphQLField(v, name)
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("connection %q: field %q doesn't exist", p.opts.Connection, name)
+		}
+		v = f
+	}
+	v = indirect(v)
+	if _, ok := graphQLField(v, "pageInfo"); !ok {
+		return reflect.Value{}, fmt.Errorf("connection %q doesn't select pageInfo", p.opts.Connection)
+	}
+	return v, nil
+}
+
+// findConnection returns the first struct within v, in depth-first order,
+// that has a pageInfo field.
+func findConnection(v reflect.Value) (reflect.Value, bool) {
+	v = indirect(v)
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	if _, ok := graphQLField(v, "pageInfo"); ok {
+		return v, true
+	}
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).PkgPath != "" {
+			continue // Unexported.
+		}
+		if conn, ok := findConnection(v.Field(i)); ok {
+			return conn, true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// graphQLField returns the field of struct v whose response key is name,
+// looking into embedded structs and fragments as well.
+func graphQLField(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue // Unexported.
+		}
+		value, ok := f.Tag.Lookup("graphql")
+		switch {
+		case f.Anonymous && !ok, ok && strings.HasPrefix(strings.TrimSpace(value), "..."):
+			if fv := indirect(v.Field(i)); fv.Kind() == reflect.Struct {
+				if fv, ok := graphQLField(fv, name); ok {
+					return fv, true
+				}
+			}
+		case ok:
+			if responseKey, _, _ := parseField(value); responseKey == name {
+				return v.Field(i), true
+			}
+		default:
+			if ident.ParseMixedCaps(f.Name).ToLowerCamelCase() == name {
+				return v.Field(i), true
+			}
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// pageInfoOf returns the page info of connection struct conn.
+func pageInfoOf(conn reflect.Value) (hasNextPage bool, endCursor string, err error) {
+	pageInfo, _ := graphQLField(conn, "pageInfo")
+	pageInfo = indirect(pageInfo)
+	if pageInfo.Kind() != reflect.Struct {
+		return false, "", errors.New("pageInfo is null")
+	}
+	f, ok := graphQLField(pageInfo, "hasNextPage")
+	if !ok {
+		return false, "", errors.New("pageInfo doesn't select hasNextPage")
+	}
+	f = indirect(f)
+	if f.Kind() != reflect.Bool {
+		return false, "", fmt.Errorf("pageInfo.hasNextPage has unexpected type %v", f.Type())
+	}
+	hasNextPage = f.Bool()
+	f, ok = graphQLField(pageInfo, "endCursor")
+	if !ok {
+		return false, "", errors.New("pageInfo doesn't select endCursor")
+	}
+	endCursor, ok = stringOf(f)
+	if hasNextPage && (!ok || endCursor == "") {
+		return false, "", errors.New("pageInfo.endCursor is null while hasNextPage is true")
+	}
+	return hasNextPage, endCursor, nil
+}
+
+// nodesOf returns pointers to the nodes of connection struct conn,
+// from its nodes field, or else the node field of its edges.
+func nodesOf(conn reflect.Value) ([]interface{}, error) {
+	var nodes []interface{}
+	if f, ok := graphQLField(conn, "nodes"); ok {
+		f = indirect(f)
+		if f.Kind() != reflect.Slice {
+			return nil, nil
+		}
+		for i := 0; i < f.Len(); i++ {
+			nodes = append(nodes, f.Index(i).Addr().Interface())
+		}
+		return nodes, nil
+	}
+	edges, ok := graphQLField(conn, "edges")
+	if !ok {
+		return nil, errors.New("connection selects neither nodes nor edges")
+	}
+	edges = indirect(edges)
+	if edges.Kind() != reflect.Slice {
+		return nil, nil
+	}
+	for i := 0; i < edges.Len(); i++ {
+		edge := indirect(edges.Index(i))
+		if edge.Kind() != reflect.Struct {
+			continue
+		}
+		node, ok := graphQLField(edge, "node")
+		if !ok {
+			return nil, errors.New("connection edges don't select node")
+		}
+		nodes = append(nodes, node.Addr().Interface())
+	}
+	return nodes, nil
+}
+
+// indirect dereferences pointers and interfaces of v.
+func indirect(v reflect.Value) reflect.Value {
+	for (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v
+}
+
+// stringOf returns the string held by v, if any.
+func stringOf(v reflect.Value) (string, bool) {
+	v = indirect(v)
+	if v.Kind() != reflect.String {
+		return "", false
+	}
+	return v.String(), true
+}
+
+// cursorValue returns cursor as a value of the same type as prev,
+// the previous value of the cursor variable, so that the declared
+// type of the variable doesn't change from one page to the next.
+func cursorValue(prev interface{}, cursor string) interface{} {
+	if tv, ok := prev.(Variable); ok {
+		tv.Value = cursorValue(tv.Value, cursor)
+		return tv
+	}
+	t := reflect.TypeOf(prev)
+	switch {
+	case t == nil:
+		return NewString(String(cursor))
+	case t.Kind() == reflect.String:
+		return reflect.ValueOf(cursor).Convert(t).Interface()
+	case t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.String:
+		v := reflect.New(t.Elem())
+		v.Elem().SetString(cursor)
+		return v.Interface()
+	default:
+		return NewString(String(cursor))
+	}
+}
@@ -0,0 +1,174 @@
+package graphql_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/InoiOy/go-graphql-client"
+)
+
+// connectionServer serves a connection of 5 issues, in pages of size variable first.
+func connectionServer(t *testing.T, queries *[]string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		var in struct {
+			Query     string
+			Variables struct {
+				Cursor *string
+				First  int
+			}
+		}
+		if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+			t.Fatal(err)
+		}
+		*queries = append(*queries, in.Query)
+		start := 0
+		if in.Variables.Cursor != nil {
+			fmt.Sscanf(*in.Variables.Cursor, "cursor%d", &start)
+		}
+		end := start + in.Variables.First
+		if end > 5 {
+			end = 5
+		}
+		var nodes []map[string]interface{}
+		for i := start; i < end; i++ {
+			nodes = append(nodes, map[string]interface{}{"number": i + 1})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"repository": map[string]interface{}{
+					"issues": map[string]interface{}{
+						"nodes": nodes,
+						"pageInfo": map[string]interface{}{
+							"hasNextPage": end < 5,
+							"endCursor":   fmt.Sprintf("cursor%d", end),
+						},
+					},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	return mux
+}
+
+type issuesQuery struct {
+	Repository struct {
+		Issues struct {
+			Nodes []struct {
+				Number graphql.Int
+			}
+			PageInfo struct {
+				HasNextPage graphql.Boolean
+				EndCursor   graphql.String
+			}
+		} `graphql:"issues(first: $first, after: $cursor)"`
+	}
+}
+
+func TestClient_Paginate(t *testing.T) {
+	var queries []string
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: connectionServer(t, &queries)}})
+
+	var q issuesQuery
+	p := client.Paginate(&q, nil, graphql.PaginationOptions{PageSize: 2})
+	var pages [][]graphql.Int
+	for p.Next(context.Background()) {
+		var page []graphql.Int
+		for _, n := range q.Repository.Issues.Nodes {
+			page = append(page, n.Number)
+		}
+		pages = append(pages, page)
+	}
+	if err := p.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := pages, [][]graphql.Int{{1, 2}, {3, 4}, {5}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got pages: %v, want: %v", got, want)
+	}
+	want := `query ($cursor:String$first:Int!){repository{issues(first: $first, after: $cursor){nodes{number},pageInfo{hasNextPage,endCursor}}}}`
+	for i, query := range queries {
+		if query != want {
+			t.Errorf("got query %d: %q, want: %q", i, query, want)
+		}
+	}
+}
+
+// Test that the declaration of a cursor variable wrapped in a Variable,
+// with a default value, is the same for all pages.
+func TestClient_Paginate_variable(t *testing.T) {
+	var queries []string
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: connectionServer(t, &queries)}})
+
+	var q issuesQuery
+	variables := map[string]interface{}{
+		"cursor": graphql.Variable{Value: (*string)(nil), Type: "String", Default: `"cursor0"`},
+	}
+	p := client.Paginate(&q, variables, graphql.PaginationOptions{PageSize: 2})
+	for p.Next(context.Background()) {
+	}
+	if err := p.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(queries), 3; got != want {
+		t.Errorf("got %d queries, want: %d", got, want)
+	}
+	want := `query ($cursor:String="cursor0"$first:Int!){repository{issues(first: $first, after: $cursor){nodes{number},pageInfo{hasNextPage,endCursor}}}}`
+	for i, query := range queries {
+		if query != want {
+			t.Errorf("got query %d: %q, want: %q", i, query, want)
+		}
+	}
+}
+
+func TestClient_Paginate_eachNode(t *testing.T) {
+	var queries []string
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: connectionServer(t, &queries)}})
+
+	var q issuesQuery
+	p := client.Paginate(&q, nil, graphql.PaginationOptions{
+		Connection: "repository.issues",
+		PageSize:   2,
+		MaxPages:   2,
+	})
+	var numbers []graphql.Int
+	err := p.EachNode(context.Background(), func(node interface{}) error {
+		numbers = append(numbers, node.(*struct{ Number graphql.Int }).Number)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := numbers, []graphql.Int{1, 2, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got numbers: %v, want: %v", got, want)
+	}
+	if got, want := p.Pages(), 2; got != want {
+		t.Errorf("got %d pages, want: %d", got, want)
+	}
+}
+
+func TestClient_Paginate_canceled(t *testing.T) {
+	var queries []string
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: connectionServer(t, &queries)}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var q issuesQuery
+	p := client.Paginate(&q, nil, graphql.PaginationOptions{PageSize: 2})
+	for p.Next(ctx) {
+		cancel()
+	}
+	if got, want := p.Err(), context.Canceled; got != want {
+		t.Errorf("got error: %v, want: %v", got, want)
+	}
+	if got, want := len(queries), 1; got != want {
+		t.Errorf("got %d requests, want: %d", got, want)
+	}
+}
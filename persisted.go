@@ -0,0 +1,155 @@
+package graphql
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"sync"
+)
+
+// Automatic persisted queries follow Apollo's protocol specification
+// https://github.com/apollographql/apollo-link-persisted-queries
+
+// persistedQueries holds the state of the automatic persisted queries protocol.
+type persistedQueries struct {
+	useGET bool // Send hashed queries as GET requests.
+
+	// documents caches the query document of each Go query type and
+	// variables shape, along with its SHA-256 hash, so that neither is
+	// computed again. Documents of types with keyed or interface fields
+	// depend on more than that, and aren't cached.
+	documents sync.Map // map[documentKey]*persistedDocument
+
+	mu          sync.Mutex
+	unsupported bool // Server reported that it doesn't support persisted queries.
+}
+
+// WithAutomaticPersistedQueries enables Apollo automatic persisted queries.
+// The client first sends the SHA-256 hash of a query instead of its text, and
+// sends the full query only when the server doesn't know the hash yet.
+//
+// If useGET is true, or WithGETQueries is enabled, hashed queries are sent as
+// GET requests, which CDNs are able to cache. Mutations and requests that
+// register the full query text are always sent as POST.
+func (c *Client) WithAutomaticPersistedQueries(useGET bool) *Client {
+	c.persistedQueries = &persistedQueries{useGET: useGET}
+	return c
+}
+
+// documentKey identifies the document of an operation on a Go query type.
+type documentKey struct {
+	op        OperationType
+	t         reflect.Type
+	name      string
+	arguments string // Variable definitions, e.g. "$login:String!".
+}
+
+// persistedDocument is a query document and its hash.
+type persistedDocument struct {
+	query string
+	hash  string
+}
+
+// persistedDocument returns the document of operation op on query struct v, and its
+// hash, from the cache if possible.
+func (c *Client) persistedDocument(op OperationType, v interface{}, variables map[string]interface{}, name string) (*persistedDocument, error) {
+	t := reflect.TypeOf(v)
+	if t == nil || hasDynamicFields(t, make(map[reflect.Type]bool)) {
+		query, err := constructOperation(op, v, variables, name, c.types)
+		if err != nil {
+			return nil, err
+		}
+		return &persistedDocument{query: query, hash: hash(query)}, nil
+	}
+	arguments, err := queryArguments(variables, c.types)
+	if err != nil {
+		return nil, err
+	}
+	key := documentKey{op: op, t: t, name: name, arguments: arguments}
+	if d, ok := c.persistedQueries.documents.Load(key); ok {
+		return d.(*persistedDocument), nil
+	}
+	query, err := constructOperation(op, v, variables, name, c.types)
+	if err != nil {
+		return nil, err
+	}
+	d := &persistedDocument{query: query, hash: hash(query)}
+	c.persistedQueries.documents.Store(key, d)
+	return d, nil
+}
+
+// hash returns the hex encoded SHA-256 hash of query.
+func hash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+func (pq *persistedQueries) isUnsupported() bool {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	return pq.unsupported
+}
+
+func (pq *persistedQueries) setUnsupported() {
+	pq.mu.Lock()
+	pq.unsupported = true
+	pq.mu.Unlock()
+}
+
+// sendPersisted sends req following the automatic persisted queries protocol.
+func (c *Client) sendPersisted(ctx context.Context, req *Request) (*Response, error) {
+	pq := c.persistedQueries
+	sha256Hash := ""
+	if d := req.persisted; d != nil && d.query == req.Query {
+		sha256Hash = d.hash
+	} else {
+		// Hand-written document, or rewritten by a middleware.
+		sha256Hash = hash(req.Query)
+	}
+
+	extensions := make(map[string]interface{}, len(req.Extensions)+1)
+	for k, v := range req.Extensions {
+		extensions[k] = v
+	}
+	extensions["persistedQuery"] = map[string]interface{}{
+		"version":    1,
+		"sha256Hash": sha256Hash,
+	}
+	hashed := *req
+	hashed.Query = ""
+	hashed.Extensions = extensions
+
+	var resp *Response
+	var err error
+	if (pq.useGET || c.getQueries) && req.Type == QueryOperation {
+		resp, err = c.get(ctx, &hashed)
+	} else {
+		resp, err = c.post(ctx, &hashed)
+	}
+	if err != nil || len(resp.Errors) == 0 {
+		return resp, err
+	}
+	switch {
+	case resp.Errors.hasError("PersistedQueryNotFound", "PERSISTED_QUERY_NOT_FOUND"):
+	case resp.Errors.hasError("PersistedQueryNotSupported", "PERSISTED_QUERY_NOT_SUPPORTED"):
+		pq.setUnsupported()
+		return c.sendOne(ctx, req)
+	default:
+		return resp, nil
+	}
+
+	// The server doesn't know the hash yet. Register it by sending the full query.
+	hashed.Query = req.Query
+	return c.post(ctx, &hashed)
+}
+
+// hasError reports whether e contains an error with the given message or extension code.
+func (e Errors) hasError(message, code string) bool {
+	for _, err := range e {
+		if err.Message == message || err.Code() == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+package graphql_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/InoiOy/go-graphql-client"
+)
+
+// persistedQueryServer is a stand-in for a GraphQL server supporting
+// automatic persisted queries. It records the method of every request
+// and whether it carried the query text.
+type persistedQueryServer struct {
+	t *testing.T
+
+	mu       sync.Mutex
+	queries  map[string]string // Hash -> query.
+	requests []string
+}
+
+func (s *persistedQueryServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var in struct {
+		Query      string
+		Extensions struct {
+			PersistedQuery struct {
+				Version    int
+				Sha256Hash string
+			}
+		}
+	}
+	switch req.Method {
+	case http.MethodGet:
+		in.Query = req.URL.Query().Get("query")
+		if err := json.Unmarshal([]byte(req.URL.Query().Get("extensions")), &in.Extensions); err != nil {
+			s.t.Error(err)
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+			s.t.Error(err)
+		}
+	}
+	hash := in.Extensions.PersistedQuery.Sha256Hash
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, req.Method+" query="+yesNo(in.Query != ""))
+	w.Header().Set("Content-Type", "application/json")
+	if in.Query != "" {
+		sum := sha256.Sum256([]byte(in.Query))
+		if got, want := hash, hex.EncodeToString(sum[:]); got != want {
+			s.t.Errorf("got hash: %q, want: %q", got, want)
+		}
+		s.queries[hash] = in.Query
+	} else if _, ok := s.queries[hash]; !ok {
+		mustWrite(w, `{"errors": [{"message": "PersistedQueryNotFound", "extensions": {"code": "PERSISTED_QUERY_NOT_FOUND"}}]}`)
+		return
+	}
+	mustWrite(w, `{"data": {"user": {"name": "Gopher"}}}`)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func TestClient_WithAutomaticPersistedQueries(t *testing.T) {
+	for _, tc := range []struct {
+		useGET bool
+		want   []string
+	}{
+		{
+			useGET: false,
+			want:   []string{"POST query=no", "POST query=yes", "POST query=no"},
+		},
+		{
+			useGET: true,
+			want:   []string{"GET query=no", "POST query=yes", "GET query=no"},
+		},
+	} {
+		s := &persistedQueryServer{t: t, queries: make(map[string]string)}
+		server := httptest.NewServer(s)
+		client := graphql.NewClient(server.URL, nil).WithAutomaticPersistedQueries(tc.useGET)
+
+		for i := 0; i < 2; i++ {
+			var q struct {
+				User struct {
+					Name graphql.String
+				}
+			}
+			err := client.Query(context.Background(), &q, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := q.User.Name, graphql.String("Gopher"); got != want {
+				t.Errorf("got q.User.Name: %q, want: %q", got, want)
+			}
+		}
+		server.Close()
+
+		if !reflect.DeepEqual(s.requests, tc.want) {
+			t.Errorf("useGET=%v: got requests: %v, want: %v", tc.useGET, s.requests, tc.want)
+		}
+	}
+}
+
+// Test that the documents of a query type are told apart by the types
+// of their variables.
+func TestClient_WithAutomaticPersistedQueries_variables(t *testing.T) {
+	s := &persistedQueryServer{t: t, queries: make(map[string]string)}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	client := graphql.NewClient(server.URL, nil).WithAutomaticPersistedQueries(false)
+
+	for _, login := range []interface{}{"gopher", graphql.NewID("gopher"), "gopher"} {
+		var q struct {
+			User struct {
+				Name graphql.String
+			} `graphql:"user(login: $login)"`
+		}
+		err := client.Query(context.Background(), &q, map[string]interface{}{"login": login})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var queries []string
+	for _, query := range s.queries {
+		queries = append(queries, query)
+	}
+	sort.Strings(queries)
+	if want := []string{
+		"query ($login:ID){user(login: $login){name}}",
+		"query ($login:String!){user(login: $login){name}}",
+	}; !reflect.DeepEqual(queries, want) {
+		t.Errorf("got queries: %q, want: %q", queries, want)
+	}
+	if want := []string{"POST query=no", "POST query=yes", "POST query=no", "POST query=yes", "POST query=no"}; !reflect.DeepEqual(s.requests, want) {
+		t.Errorf("got requests: %v, want: %v", s.requests, want)
+	}
+}
+
+func TestClient_WithAutomaticPersistedQueries_notSupported(t *testing.T) {
+	for _, tc := range []struct {
+		getQueries bool
+		want       []string
+	}{
+		{
+			getQueries: false,
+			want:       []string{"POST query=no", "POST query=yes", "POST query=yes"},
+		},
+		{
+			// The fallback follows WithGETQueries.
+			getQueries: true,
+			want:       []string{"GET query=no", "GET query=yes", "GET query=yes"},
+		},
+	} {
+		var requests []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			var query string
+			if req.Method == http.MethodGet {
+				query = req.URL.Query().Get("query")
+			} else {
+				var in struct{ Query string }
+				if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+					t.Error(err)
+				}
+				query = in.Query
+			}
+			requests = append(requests, req.Method+" query="+yesNo(query != ""))
+			w.Header().Set("Content-Type", "application/json")
+			if query == "" {
+				mustWrite(w, `{"errors": [{"message": "PersistedQueryNotSupported"}]}`)
+				return
+			}
+			mustWrite(w, `{"data": {"user": {"name": "Gopher"}}}`)
+		}))
+		client := graphql.NewClient(server.URL, nil).WithAutomaticPersistedQueries(false)
+		if tc.getQueries {
+			client = client.WithGETQueries(0)
+		}
+
+		for i := 0; i < 2; i++ {
+			var q struct {
+				User struct {
+					Name graphql.String
+				}
+			}
+			if err := client.Query(context.Background(), &q, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		server.Close()
+
+		// After the first attempt, the client should stop sending hashed queries.
+		if !reflect.DeepEqual(requests, tc.want) {
+			t.Errorf("getQueries=%v: got requests: %v, want: %v", tc.getQueries, requests, tc.want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package graphql
+
+import (
+	"reflect"
+
+	"github.com/InoiOy/go-graphql-client/internal/jsonutil"
+)
+
+// RegisterType registers the Go type of v, a struct or pointer to struct,
+// as the type of objects of GraphQL type typename, e.g. "Human".
+//
+// Fields of non-empty interface types are queried with an inline fragment
+// for each registered type that implements the interface (or whose pointer
+// does), and decoded into the type named by the object's __typename.
+//
+// Registered types are process-wide: they're shared by all clients.
+// RegisterType is meant to be called during initialization.
+func RegisterType(typename string, v interface{}) {
+	jsonutil.RegisterType(typename, reflect.TypeOf(v))
+}
+
+// RegisterPossibleTypes registers the possible types of abstract GraphQL
+// type abstract, an interface or union, e.g. "Character" for "Human" and
+// "Droid". Fragments on abstract are then populated for objects of any of
+// its possible types, rather than only for objects whose __typename is
+// abstract itself.
+//
+// Possible types are process-wide: they're shared by all clients.
+// RegisterPossibleTypes is meant to be called during initialization.
+func RegisterPossibleTypes(abstract string, possible ...string) {
+	jsonutil.RegisterPossibleTypes(abstract, possible...)
+}
@@ -3,51 +3,124 @@ package graphql
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"reflect"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/InoiOy/go-graphql-client/ident"
+	"github.com/InoiOy/go-graphql-client/internal/jsonutil"
 )
 
-func constructQuery(v interface{}, variables map[string]interface{}, name string) string {
-	query := query(v)
-	if len(variables) > 0 {
-		return "query " + name + "(" + queryArguments(variables) + ")" + query
-	}
+// VariableDefinition is the definition of a variable of an operation,
+// e.g. $login: String!.
+type VariableDefinition struct {
+	Name    string // Name of the variable, without "$".
+	Type    string // GraphQL type of the variable, e.g. "String!".
+	Default string // GraphQL literal of its default value, if any, e.g. "10".
+}
 
-	if name != "" {
-		return "query " + name + query
-	}
-	return query
+// ConstructQuery returns the query document that Query sends for query
+// struct v, variables and operation name name, along with its variable
+// definitions. name can be empty. Type names registered with
+// Client.WithTypeName aren't known to it; use Client.ConstructQuery instead.
+func ConstructQuery(v interface{}, variables interface{}, name string) (string, []VariableDefinition, error) {
+	return constructDocument("query", v, variables, name, nil)
 }
 
-func constructMutation(v interface{}, variables map[string]interface{}, name string) string {
-	query := query(v)
-	if len(variables) > 0 {
-		return "mutation " + name + "(" + queryArguments(variables) + ")" + query
-	}
-	if name != "" {
-		return "mutation " + name + query
+// ConstructMutation returns the mutation document that Mutate sends for
+// mutation struct m, variables and operation name name, along with its variable
+// definitions. name can be empty. Type names registered with
+// Client.WithTypeName aren't known to it; use Client.ConstructMutation instead.
+func ConstructMutation(m interface{}, variables interface{}, name string) (string, []VariableDefinition, error) {
+	return constructDocument("mutation", m, variables, name, nil)
+}
+
+// ConstructSubscription returns the subscription document that
+// SubscriptionClient sends for subscription struct v, variables and operation
+// name name, along with its variable definitions. name can be empty. Type names
+// registered with WithTypeName aren't known to it; use Client.ConstructSubscription
+// instead.
+func ConstructSubscription(v interface{}, variables interface{}, name string) (string, []VariableDefinition, error) {
+	return constructDocument("subscription", v, variables, name, nil)
+}
+
+// ConstructQuery returns the query document that c.Query sends for query
+// struct v, variables and operation name name, along with its variable
+// definitions, using the type names registered with WithTypeName.
+func (c *Client) ConstructQuery(v interface{}, variables interface{}, name string) (string, []VariableDefinition, error) {
+	return constructDocument("query", v, variables, name, c.types)
+}
+
+// ConstructMutation returns the mutation document that c.Mutate sends for
+// mutation struct m, variables and operation name name, along with its
+// variable definitions, using the type names registered with WithTypeName.
+func (c *Client) ConstructMutation(m interface{}, variables interface{}, name string) (string, []VariableDefinition, error) {
+	return constructDocument("mutation", m, variables, name, c.types)
+}
+
+// ConstructSubscription returns the subscription document for subscription
+// struct v, variables and operation name name, along with its variable
+// definitions, using the type names registered with WithTypeName. It's the
+// document sent by a SubscriptionClient with the same type names.
+func (c *Client) ConstructSubscription(v interface{}, variables interface{}, name string) (string, []VariableDefinition, error) {
+	return constructDocument("subscription", v, variables, name, c.types)
+}
+
+func constructDocument(operation string, v interface{}, variables interface{}, name string, types typeNames) (string, []VariableDefinition, error) {
+	vars, err := variablesMap(variables)
+	if err != nil {
+		return "", nil, err
 	}
-	return "mutation" + query
+	return construct(operation, v, vars, name, types)
+}
+
+func constructQuery(v interface{}, variables map[string]interface{}, name string, types typeNames) (string, error) {
+	document, _, err := construct("query", v, variables, name, types)
+	return document, err
 }
 
-func constructSubscription(v interface{}, variables map[string]interface{}, name string) string {
-	query := query(v)
-	if len(variables) > 0 {
-		return "subscription " + name + "(" + queryArguments(variables) + ")" + query
+func constructMutation(v interface{}, variables map[string]interface{}, name string, types typeNames) (string, error) {
+	document, _, err := construct("mutation", v, variables, name, types)
+	return document, err
+}
+
+func constructSubscription(v interface{}, variables map[string]interface{}, name string, types typeNames) (string, error) {
+	document, _, err := construct("subscription", v, variables, name, types)
+	return document, err
+}
+
+// construct returns the document of operation, "query", "mutation" or
+// "subscription", for struct v, variables and operation name name, along
+// with its variable definitions.
+func construct(operation string, v interface{}, variables map[string]interface{}, name string, types typeNames) (string, []VariableDefinition, error) {
+	query, err := query(v)
+	if err != nil {
+		return "", nil, err
 	}
-	if name != "" {
-		return "subscription " + name + query
+	definitions, err := variableDefinitions(variables, types)
+	if err != nil {
+		return "", nil, err
+	}
+	switch {
+	case len(definitions) > 0:
+		return operation + " " + name + "(" + formatDefinitions(definitions) + ")" + query, definitions, nil
+	case name != "":
+		return operation + " " + name + query, definitions, nil
+	case operation == "query":
+		// Query shorthand.
+		return query, definitions, nil
+	default:
+		return operation + query, definitions, nil
 	}
-	return "subscription" + query
 }
 
-// queryArguments constructs a minified arguments string for variables.
-//
-// E.g., map[string]interface{}{"a": Int(123), "b": NewBoolean(true)} -> "$a:Int!$b:Boolean".
-func queryArguments(variables map[string]interface{}) string {
+// variableDefinitions returns the definitions of variables, sorted by name.
+// types maps Go types to GraphQL type names, in addition to the defaults.
+func variableDefinitions(variables map[string]interface{}, types typeNames) ([]VariableDefinition, error) {
 	// Sort keys in order to produce deterministic output for testing purposes.
 	// TODO: If tests can be made to work with non-deterministic output, then no need to sort.
 	keys := make([]string, 0, len(variables))
@@ -56,12 +129,60 @@ func queryArguments(variables map[string]interface{}) string {
 	}
 	sort.Strings(keys)
 
-	var buf bytes.Buffer
+	definitions := make([]VariableDefinition, 0, len(keys))
 	for _, k := range keys {
+		value, definition := variables[k], VariableDefinition{Name: k}
+		if tv, ok := value.(Variable); ok {
+			value, definition.Default = tv.Value, tv.Default
+			if tv.Type != "" {
+				typ, err := parseType(tv.Type)
+				if err != nil {
+					return nil, fmt.Errorf("variable %q: %v", k, err)
+				}
+				definition.Type = typ
+				definitions = append(definitions, definition)
+				continue
+			}
+		}
+		t := reflect.TypeOf(value)
+		if t == nil {
+			return nil, fmt.Errorf("variable %q is nil, so its type can't be determined; use a typed nil pointer instead", k)
+		}
+		var buf bytes.Buffer
+		if err := writeArgumentType(&buf, t, true, types); err != nil {
+			return nil, fmt.Errorf("variable %q: %v", k, err)
+		}
+		definition.Type = buf.String()
+		definitions = append(definitions, definition)
+	}
+	return definitions, nil
+}
+
+// queryArguments constructs a minified arguments string for variables.
+//
+// E.g., map[string]interface{}{"a": Int(123), "b": NewBoolean(true)} -> "$a:Int!$b:Boolean".
+// Default values are written after the type, e.g. "$first:Int=10".
+func queryArguments(variables map[string]interface{}, types typeNames) (string, error) {
+	definitions, err := variableDefinitions(variables, types)
+	if err != nil {
+		return "", err
+	}
+	return formatDefinitions(definitions), nil
+}
+
+// formatDefinitions returns the minified variable definitions of an operation,
+// e.g. "$a:Int!$b:Boolean".
+func formatDefinitions(definitions []VariableDefinition) string {
+	var buf bytes.Buffer
+	for _, d := range definitions {
 		io.WriteString(&buf, "$")
-		io.WriteString(&buf, k)
+		io.WriteString(&buf, d.Name)
 		io.WriteString(&buf, ":")
-		writeArgumentType(&buf, reflect.TypeOf(variables[k]), true)
+		io.WriteString(&buf, d.Type)
+		if d.Default != "" {
+			io.WriteString(&buf, "=")
+			io.WriteString(&buf, d.Default)
+		}
 		// Don't insert a comma here.
 		// Commas in GraphQL are insignificant, and we want minified output.
 		// See https://facebook.github.io/graphql/October2016/#sec-Insignificant-Commas.
@@ -69,27 +190,97 @@ func queryArguments(variables map[string]interface{}) string {
 	return buf.String()
 }
 
+// GraphQLType is implemented by Go types that declare their own GraphQL
+// type name when used as variables, e.g. "UUID". The method is called on
+// the zero value of the type.
+type GraphQLType interface {
+	GetGraphQLType() string
+}
+
+var graphQLTypeInterface = reflect.TypeOf((*GraphQLType)(nil)).Elem()
+
+// typeNames maps Go types to GraphQL type names.
+type typeNames map[reflect.Type]string
+
+// with declares name as the GraphQL type name of the Go type of v,
+// allocating types if nil.
+func (types typeNames) with(v interface{}, name string) typeNames {
+	if types == nil {
+		types = make(typeNames)
+	}
+	types[reflect.TypeOf(v)] = name
+	return types
+}
+
+// defaultTypeNames maps native Go types to built-in GraphQL scalars.
+var defaultTypeNames = typeNames{
+	reflect.TypeOf(""):          "String",
+	reflect.TypeOf(false):       "Boolean",
+	reflect.TypeOf(int(0)):      "Int",
+	reflect.TypeOf(int8(0)):     "Int",
+	reflect.TypeOf(int16(0)):    "Int",
+	reflect.TypeOf(int32(0)):    "Int",
+	reflect.TypeOf(int64(0)):    "Int",
+	reflect.TypeOf(uint(0)):     "Int",
+	reflect.TypeOf(uint8(0)):    "Int",
+	reflect.TypeOf(uint16(0)):   "Int",
+	reflect.TypeOf(uint32(0)):   "Int",
+	reflect.TypeOf(uint64(0)):   "Int",
+	reflect.TypeOf(float32(0)):  "Float",
+	reflect.TypeOf(float64(0)):  "Float",
+	reflect.TypeOf(time.Time{}): "DateTime",
+
+	// ID is an interface type: a value converted to ID keeps the type of
+	// the converted value, so only pointers to ID and slices of ID are
+	// declared as ID.
+	reflect.TypeOf((*ID)(nil)).Elem(): "ID",
+}
+
+// typeName returns the GraphQL type name of named Go type t. It's looked up
+// in types, then the GraphQLType interface, then the default type names.
+// Otherwise, the name of t is used, as for the scalar types of this package
+// and custom enums.
+func typeName(t reflect.Type, types typeNames) (string, error) {
+	if name, ok := types[t]; ok {
+		return name, nil
+	}
+	switch {
+	case t.Implements(graphQLTypeInterface):
+		return reflect.Zero(t).Interface().(GraphQLType).GetGraphQLType(), nil
+	case reflect.PtrTo(t).Implements(graphQLTypeInterface):
+		return reflect.New(t).Interface().(GraphQLType).GetGraphQLType(), nil
+	}
+	if name, ok := defaultTypeNames[t]; ok {
+		return name, nil
+	}
+	if t.Name() == "" {
+		return "", fmt.Errorf("unnamed type %v has no GraphQL type name", t)
+	}
+	return t.Name(), nil
+}
+
 // writeArgumentType writes a minified GraphQL type for t to w.
 // value indicates whether t is a value (required) type or pointer (optional) type.
 // If value is true, then "!" is written at the end of t.
-func writeArgumentType(w io.Writer, t reflect.Type, value bool) {
+func writeArgumentType(w io.Writer, t reflect.Type, value bool, types typeNames) error {
 	if t.Kind() == reflect.Ptr {
 		// Pointer is an optional type, so no "!" at the end of the pointer's underlying type.
-		writeArgumentType(w, t.Elem(), false)
-		return
+		return writeArgumentType(w, t.Elem(), false, types)
 	}
 
 	switch t.Kind() {
 	case reflect.Slice, reflect.Array:
 		// List. E.g., "[Int]".
 		io.WriteString(w, "[")
-		writeArgumentType(w, t.Elem(), true)
+		if err := writeArgumentType(w, t.Elem(), true, types); err != nil {
+			return err
+		}
 		io.WriteString(w, "]")
 	default:
 		// Named type. E.g., "Int".
-		name := t.Name()
-		if name == "string" { // HACK: Workaround for https://github.com/shurcooL/githubv4/issues/12.
-			name = "ID"
+		name, err := typeName(t, types)
+		if err != nil {
+			return err
 		}
 		io.WriteString(w, name)
 	}
@@ -98,52 +289,277 @@ func writeArgumentType(w io.Writer, t reflect.Type, value bool) {
 		// Value is a required type, so add "!" to the end.
 		io.WriteString(w, "!")
 	}
+	return nil
 }
 
 // query uses writeQuery to recursively construct
-// a minified query string from the provided struct v.
+// a minified query string from the provided struct v,
+// followed by the definitions of the named fragments it spreads.
 //
 // E.g., struct{Foo Int, BarBaz *Boolean} -> "{foo,barBaz}".
-func query(v interface{}) string {
+func query(v interface{}) (string, error) {
+	t, rv := reflect.TypeOf(v), reflect.ValueOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		if rv.IsValid() && !rv.IsNil() {
+			rv = rv.Elem()
+		} else {
+			rv = reflect.Value{} // Only the type is known.
+		}
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return "", fmt.Errorf("cannot construct query from %T, want pointer to struct", v)
+	}
 	var buf bytes.Buffer
-	writeQuery(&buf, reflect.TypeOf(v), false)
-	return buf.String()
+	fragments := fragmentDefinitions{types: make(map[string]reflect.Type)}
+	if err := writeQuery(&buf, t, rv, false, &fragments); err != nil {
+		return "", err
+	}
+	buf.Write(fragments.buf.Bytes())
+	return buf.String(), nil
 }
 
 // writeQuery writes a minified query for t to w.
 // If inline is true, the struct fields of t are inlined into parent struct.
-func writeQuery(w io.Writer, t reflect.Type, inline bool) {
+// Named fragments are spread, and their definitions are added to fragments.
+// v is the value of type t, if known, from which keyed fields get their keys.
+func writeQuery(w io.Writer, t reflect.Type, v reflect.Value, inline bool, fragments *fragmentDefinitions) error {
 	switch t.Kind() {
-	case reflect.Ptr, reflect.Slice:
-		writeQuery(w, t.Elem(), false)
+	case reflect.Ptr:
+		if v.IsValid() && v.Kind() == reflect.Ptr && !v.IsNil() {
+			v = v.Elem()
+		} else {
+			v = reflect.Value{}
+		}
+		return writeQuery(w, t.Elem(), v, false, fragments)
+	case reflect.Slice:
+		return writeQuery(w, t.Elem(), reflect.Value{}, false, fragments)
 	case reflect.Struct:
 		// If the type implements json.Unmarshaler, it's a scalar. Don't expand it.
 		if reflect.PtrTo(t).Implements(jsonUnmarshaler) {
-			return
+			return nil
 		}
 		if !inline {
 			io.WriteString(w, "{")
 		}
-		for i := 0; i < t.NumField(); i++ {
-			if i != 0 {
-				io.WriteString(w, ",")
+		if name, typeCondition, ok := jsonutil.FragmentOf(t); ok {
+			io.WriteString(w, "..."+name)
+			if err := fragments.define(t, name, typeCondition); err != nil {
+				return err
 			}
-			f := t.Field(i)
-			value, ok := f.Tag.Lookup("graphql")
-			inlineField := f.Anonymous && !ok
-			if !inlineField {
-				if ok {
-					io.WriteString(w, value)
-				} else {
-					io.WriteString(w, ident.ParseMixedCaps(f.Name).ToLowerCamelCase())
-				}
+		} else {
+			written := !inline && writeTypename(w, t)
+			written, err := writeFields(w, t, v, fragments, written)
+			if err != nil {
+				return err
+			}
+			if !inline && !written {
+				return fmt.Errorf("selection set of %v is empty", t)
 			}
-			writeQuery(w, f.Type, inlineField)
 		}
 		if !inline {
 			io.WriteString(w, "}")
 		}
+	case reflect.Interface:
+		// Interface whose implementations are registered with RegisterType.
+		// Select each of them with an inline fragment.
+		impls := jsonutil.Implementations(t)
+		if len(impls) == 0 {
+			return nil
+		}
+		io.WriteString(w, "{__typename")
+		for _, impl := range impls {
+			io.WriteString(w, ",... on "+impl.Typename)
+			if err := writeQuery(w, impl.Type, reflect.Value{}, false, fragments); err != nil {
+				return err
+			}
+		}
+		io.WriteString(w, "}")
+	}
+	return nil
+}
+
+// writeTypename writes the __typename field if struct t selects fragments
+// with a type condition but not __typename, which tells the decoder what
+// fragments apply to an object. It reports whether it wrote the field.
+func writeTypename(w io.Writer, t reflect.Type) bool {
+	if typeConditions, typename := jsonutil.SelectsTypename(t); typeConditions && !typename {
+		io.WriteString(w, "__typename")
+		return true
+	}
+	return false
+}
+
+// writeFields writes the fields of struct t to w, separated by commas.
+// v is the value of struct t, if known. written tells whether fields were
+// already written to the selection set, and the returned bool whether any
+// field was, since keyed fields without keys write nothing.
+func writeFields(w io.Writer, t reflect.Type, v reflect.Value, fragments *fragmentDefinitions, written bool) (bool, error) {
+	var buf bytes.Buffer
+	for i := 0; i < t.NumField(); i++ {
+		var fv reflect.Value
+		if v.IsValid() {
+			fv = v.Field(i)
+		}
+		buf.Reset()
+		if err := writeField(&buf, t.Field(i), fv, fragments); err != nil {
+			return false, err
+		}
+		if buf.Len() == 0 {
+			continue
+		}
+		if written {
+			io.WriteString(w, ",")
+		}
+		w.Write(buf.Bytes())
+		written = true
+	}
+	return written, nil
+}
+
+// writeField writes struct field f, whose value is v if known, to w.
+func writeField(w io.Writer, f reflect.StructField, v reflect.Value, fragments *fragmentDefinitions) error {
+	value, ok := f.Tag.Lookup("graphql")
+	if name, spread := jsonutil.NamedSpread(value); ok && spread {
+		// Spread of a named fragment, e.g. "...UserFields".
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		fragment, typeCondition, ok := jsonutil.FragmentOf(ft)
+		if !ok || fragment != name {
+			return fmt.Errorf("field %s spreads fragment %s, but its type %v doesn't implement GraphQLFragment with that name", f.Name, name, f.Type)
+		}
+		io.WriteString(w, value)
+		return fragments.define(ft, name, typeCondition)
+	}
+	if prefix, ok := jsonutil.KeyedField(f); ok {
+		return writeKeyedField(w, f, v, prefix, value, fragments)
+	}
+	inlineField := f.Anonymous && !ok
+	if !inlineField {
+		if ok {
+			io.WriteString(w, value)
+		} else {
+			io.WriteString(w, ident.ParseMixedCaps(f.Name).ToLowerCamelCase())
+		}
+	}
+	return writeQuery(w, f.Type, v, inlineField, fragments)
+}
+
+// writeKeyedField writes keyed field f, whose value is m and graphql tag
+// is value, as one field per key of m, e.g. for the map keys "a" and "b":
+//
+//	repositories_0: repository(name: "a"){...},repositories_1: repository(name: "b"){...}
+func writeKeyedField(w io.Writer, f reflect.StructField, m reflect.Value, prefix, value string, fragments *fragmentDefinitions) error {
+	if !m.IsValid() {
+		return fmt.Errorf("keyed field %s: keys are unknown within lists and named fragments", f.Name)
+	}
+	if m.Kind() == reflect.Ptr {
+		m = m.Elem()
+	}
+	value = strings.TrimSpace(value)
+	if i := strings.IndexAny(value, ":(@"); i != -1 && value[i] == ':' {
+		// Drop the alias, which is the prefix of the generated aliases.
+		value = strings.TrimSpace(value[i+1:])
+	}
+	if !m.IsValid() || m.Len() == 0 {
+		return nil // No keys, no fields.
+	}
+	for i, k := range jsonutil.SortedMapKeys(m) {
+		literal, err := keyLiteral(k)
+		if err != nil {
+			return fmt.Errorf("keyed field %s: %v", f.Name, err)
+		}
+		if i != 0 {
+			io.WriteString(w, ",")
+		}
+		io.WriteString(w, prefix+"_"+strconv.Itoa(i)+": "+substituteKey(value, literal))
+		if err := writeQuery(w, m.Type().Elem(), m.MapIndex(k), false, fragments); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyLiteral returns the GraphQL literal of map key k: a string or an integer.
+func keyLiteral(k reflect.Value) (string, error) {
+	switch k.Kind() {
+	case reflect.String:
+		b, err := json.Marshal(k.String())
+		return string(b), err
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	default:
+		return "", fmt.Errorf("unsupported key type %v", k.Type())
+	}
+}
+
+// substituteKey replaces the $key placeholders of s with literal.
+func substituteKey(s, literal string) string {
+	var buf strings.Builder
+	for {
+		i := strings.Index(s, "$key")
+		if i == -1 {
+			buf.WriteString(s)
+			return buf.String()
+		}
+		end := i + len("$key")
+		if end < len(s) && isNameChar(s[end]) {
+			// Another variable, e.g. $keys.
+			buf.WriteString(s[:end])
+		} else {
+			buf.WriteString(s[:i])
+			buf.WriteString(literal)
+		}
+		s = s[end:]
+	}
+}
+
+// GraphQLFragment is implemented by struct types that are named fragments,
+// so that a type selected in many places of a query is defined once.
+// Wherever such a type is selected, as the type of a field, an embedded
+// struct or a field tagged with its spread, e.g. `graphql:"...UserFields"`,
+// the fragment is spread, and its definition is appended to the document:
+//
+//	fragment UserFields on User{login,name}
+//
+// The method is called on the zero value of the type.
+type GraphQLFragment interface {
+	GetGraphQLFragment() (name, typeCondition string)
+}
+
+// fragmentDefinitions collects the definitions of the named fragments
+// of a query, in the order they're defined.
+type fragmentDefinitions struct {
+	buf   bytes.Buffer
+	types map[string]reflect.Type // Fragment name -> Go type.
+}
+
+// define adds the definition of fragment name on typeCondition,
+// whose selection set is given by struct t, unless already defined.
+func (d *fragmentDefinitions) define(t reflect.Type, name, typeCondition string) error {
+	if prev, ok := d.types[name]; ok {
+		if prev != t {
+			return fmt.Errorf("fragment %s is defined by both %v and %v", name, prev, t)
+		}
+		return nil
+	}
+	d.types[name] = t
+	var buf bytes.Buffer
+	io.WriteString(&buf, "fragment "+name+" on "+typeCondition+"{")
+	written, err := writeFields(&buf, t, reflect.Value{}, d, writeTypename(&buf, t))
+	if err != nil {
+		return err
+	}
+	if !written {
+		return fmt.Errorf("selection set of fragment %s is empty", name)
 	}
+	io.WriteString(&buf, "}")
+	d.buf.Write(buf.Bytes())
+	return nil
 }
 
 var jsonUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
@@ -1,7 +1,10 @@
 package graphql
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/url"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -217,7 +220,7 @@ func TestConstructQuery(t *testing.T) {
 					}
 				}{}
 			}(),
-			want: `{actor{login,avatarUrl,url},createdAt,... on IssueComment{body},currentTitle,previousTitle,label{name,color}}`,
+			want: `{__typename,actor{login,avatarUrl,url},createdAt,... on IssueComment{body},currentTitle,previousTitle,label{name,color}}`,
 		},
 		{
 			inV: struct {
@@ -230,9 +233,39 @@ func TestConstructQuery(t *testing.T) {
 			}{},
 			want: `{viewer{login,createdAt,id,databaseId}}`,
 		},
+		{
+			inV: struct {
+				User struct {
+					ID     ID
+					Fields struct {
+						Bio String
+					} `graphql:"... @defer(label: \"bio\")"`
+					Friends []struct {
+						Name String
+					} `graphql:"friends @stream(initialCount: 1)"`
+				} `graphql:"user(id: 1)"`
+			}{},
+			want: `{user(id: 1){id,... @defer(label: "bio"){bio},friends @stream(initialCount: 1){name}}}`,
+		},
+		{
+			inV: struct {
+				User struct {
+					Login String
+					Email String `graphql:"email @include(if: $withEmail)"`
+					Admin struct {
+						Role String
+					} `graphql:"... on Admin @skip(if: $public)"`
+					Avatar String `graphql:"small: avatarUrl(size: 16) @include(if: true)"`
+				} `graphql:"user(login: $login)"`
+			}{},
+			want: `{user(login: $login){__typename,login,email @include(if: $withEmail),... on Admin @skip(if: $public){role},small: avatarUrl(size: 16) @include(if: true)}}`,
+		},
 	}
 	for _, tc := range tests {
-		got := constructQuery(tc.inV, tc.inVariables, tc.name)
+		got, err := constructQuery(tc.inV, tc.inVariables, tc.name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
 		if got != tc.want {
 			t.Errorf("\ngot:  %q\nwant: %q\n", got, tc.want)
 		}
@@ -267,7 +300,10 @@ func TestConstructMutation(t *testing.T) {
 		},
 	}
 	for _, tc := range tests {
-		got := constructMutation(tc.inV, tc.inVariables, "")
+		got, err := constructMutation(tc.inV, tc.inVariables, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
 		if got != tc.want {
 			t.Errorf("\ngot:  %q\nwant: %q\n", got, tc.want)
 		}
@@ -485,7 +521,7 @@ func TestConstructSubscription(t *testing.T) {
 					}
 				}{}
 			}(),
-			want: `subscription{actor{login,avatarUrl,url},createdAt,... on IssueComment{body},currentTitle,previousTitle,label{name,color}}`,
+			want: `subscription{__typename,actor{login,avatarUrl,url},createdAt,... on IssueComment{body},currentTitle,previousTitle,label{name,color}}`,
 		},
 		{
 			inV: struct {
@@ -500,13 +536,232 @@ func TestConstructSubscription(t *testing.T) {
 		},
 	}
 	for _, tc := range tests {
-		got := constructSubscription(tc.inV, tc.inVariables, tc.name)
+		got, err := constructSubscription(tc.inV, tc.inVariables, tc.name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
 		if got != tc.want {
 			t.Errorf("\ngot:  %q\nwant: %q\n", got, tc.want)
 		}
 	}
 }
 
+func TestConstructQuery_exported(t *testing.T) {
+	var q struct {
+		User struct {
+			Name String
+		} `graphql:"user(login: $login, first: $first)"`
+	}
+	got, definitions, err := ConstructQuery(&q, map[string]interface{}{
+		"login": String("gopher"),
+		"first": (*Int)(nil),
+	}, "GetUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `query GetUser($first:Int$login:String!){user(login: $login, first: $first){name}}`; got != want {
+		t.Errorf("\ngot:  %q\nwant: %q\n", got, want)
+	}
+	if want := []VariableDefinition{{Name: "first", Type: "Int"}, {Name: "login", Type: "String!"}}; !reflect.DeepEqual(definitions, want) {
+		t.Errorf("got definitions: %v, want: %v", definitions, want)
+	}
+
+	_, _, err = ConstructQuery(&q, map[string]interface{}{"login": nil}, "")
+	if got, want := fmt.Sprint(err), `variable "login" is nil, so its type can't be determined; use a typed nil pointer instead`; got != want {
+		t.Errorf("got error: %v, want: %v", got, want)
+	}
+	_, _, err = ConstructMutation(&q, map[string]interface{}{"input": map[string]interface{}{}}, "")
+	if got, want := fmt.Sprint(err), `variable "input": unnamed type map[string]interface {} has no GraphQL type name`; got != want {
+		t.Errorf("got error: %v, want: %v", got, want)
+	}
+	_, _, err = ConstructSubscription(nil, nil, "")
+	if got, want := fmt.Sprint(err), `cannot construct query from <nil>, want pointer to struct`; got != want {
+		t.Errorf("got error: %v, want: %v", got, want)
+	}
+}
+
+// userFields is a named fragment for testing.
+type userFields struct {
+	Login  String
+	Avatar String `graphql:"avatarUrl(size: 32)"`
+	Owner  *repositoryOwnerFields
+}
+
+func (userFields) GetGraphQLFragment() (string, string) { return "UserFields", "User" }
+
+// repositoryOwnerFields is a named fragment, with a pointer receiver, for testing.
+type repositoryOwnerFields struct {
+	ID ID
+}
+
+func (*repositoryOwnerFields) GetGraphQLFragment() (string, string) {
+	return "OwnerFields", "RepositoryOwner"
+}
+
+func TestConstructQuery_namedFragments(t *testing.T) {
+	var q struct {
+		Viewer userFields
+		User   struct {
+			userFields
+			Followers []userFields `graphql:"followers(first: 10)"`
+		} `graphql:"user(login: $login)"`
+		Node struct {
+			Fields userFields `graphql:"...UserFields @include(if: $withUser)"`
+		} `graphql:"node(id: $id)"`
+	}
+	got, err := constructQuery(&q, map[string]interface{}{"login": String(""), "id": Variable{Value: "", Type: "ID!"}, "withUser": Boolean(true)}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `query ($id:ID!$login:String!$withUser:Boolean!){viewer{...UserFields},user(login: $login){__typename,...UserFields,followers(first: 10){...UserFields}},node(id: $id){__typename,...UserFields @include(if: $withUser)}}` +
+		`fragment OwnerFields on RepositoryOwner{id}fragment UserFields on User{login,avatarUrl(size: 32),owner{...OwnerFields}}`
+	if got != want {
+		t.Errorf("\ngot:  %q\nwant: %q", got, want)
+	}
+}
+
+func TestConstructQuery_namedFragmentsErrors(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{
+			v: &struct {
+				Viewer struct {
+					Login String
+				} `graphql:"...UserFields"`
+			}{},
+			want: "field Viewer spreads fragment UserFields, but its type struct { Login graphql.String } doesn't implement GraphQLFragment with that name",
+		},
+		{
+			v: &struct {
+				Viewer userFields
+				Owner  struct {
+					ID ID
+				}
+				Other struct {
+					Other otherUserFields
+				}
+			}{},
+			want: "fragment UserFields is defined by both graphql.userFields and graphql.otherUserFields",
+		},
+	}
+	for i, tc := range tests {
+		_, err := constructQuery(tc.v, nil, "", nil)
+		if got := fmt.Sprint(err); got != tc.want {
+			t.Errorf("test case %d: got error: %v, want: %v", i, got, tc.want)
+		}
+	}
+}
+
+type otherUserFields struct {
+	Name String
+}
+
+func (otherUserFields) GetGraphQLFragment() (string, string) { return "UserFields", "User" }
+
+func TestConstructQuery_keyedFields(t *testing.T) {
+	type repository struct {
+		Stars  Int `graphql:"stargazerCount"`
+		Issues map[int]*struct {
+			Title String
+		} `graphql:"issue(number: $key)"`
+	}
+	q := struct {
+		Repositories map[string]repository `graphql:"repository(owner: $owner, name: $key)"`
+		Users        map[string]struct {
+			Name String
+		} `graphql:"u: user(login: $key) @include(if: $withUsers)"`
+	}{
+		Repositories: map[string]repository{
+			"go":    {},
+			"tools": {Issues: map[int]*struct{ Title String }{42: nil, 7: nil}},
+		},
+		Users: map[string]struct{ Name String }{`"quoted"`: {}},
+	}
+	got, err := constructQuery(&q, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{repositories_0: repository(owner: $owner, name: "go"){stargazerCount},` +
+		`repositories_1: repository(owner: $owner, name: "tools"){stargazerCount,issues_0: issue(number: 7){title},issues_1: issue(number: 42){title}},` +
+		`u_0: user(login: "\"quoted\"") @include(if: $withUsers){name}}`
+	if got != want {
+		t.Errorf("\ngot:  %q\nwant: %q", got, want)
+	}
+
+	var list struct {
+		Nodes []struct {
+			Users map[string]struct{ Name String } `graphql:"user(login: $key)"`
+		}
+	}
+	_, err = constructQuery(&list, nil, "", nil)
+	if got, want := fmt.Sprint(err), "keyed field Users: keys are unknown within lists and named fragments"; got != want {
+		t.Errorf("got error: %v, want: %v", got, want)
+	}
+
+	// Keyed fields without keys write nothing, nor separators.
+	type viewer struct {
+		Repositories map[string]struct{ Name String } `graphql:"repository(name: $key)"`
+		Login        String
+	}
+	got, err = constructQuery(&struct{ Viewer viewer }{}, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{viewer{login}}`; got != want {
+		t.Errorf("\ngot:  %q\nwant: %q", got, want)
+	}
+	type repositories struct {
+		Repositories map[string]struct{ Name String } `graphql:"repository(name: $key)"`
+	}
+	_, err = constructQuery(&struct{ Viewer repositories }{}, nil, "", nil)
+	if got, want := fmt.Sprint(err), "selection set of graphql.repositories is empty"; got != want {
+		t.Errorf("got error: %v, want: %v", got, want)
+	}
+}
+
+type starWarsCharacter interface {
+	characterName() string
+}
+
+type starWarsHuman struct {
+	Name   String
+	Height Float
+}
+
+func (h starWarsHuman) characterName() string { return string(h.Name) }
+
+type starWarsDroid struct {
+	Name            String
+	PrimaryFunction String
+}
+
+func (d *starWarsDroid) characterName() string { return string(d.Name) }
+
+func TestConstructQuery_interfaces(t *testing.T) {
+	RegisterType("Human", starWarsHuman{})
+	RegisterType("Droid", &starWarsDroid{})
+	var q struct {
+		Hero    starWarsCharacter `graphql:"hero(episode: $episode)"`
+		Friends []starWarsCharacter
+		Search  []struct {
+			Human starWarsHuman `graphql:"... on Human"`
+			Droid starWarsDroid `graphql:"... on Droid"`
+		} `graphql:"search(text: $text)"`
+	}
+	got, err := constructQuery(&q, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{hero(episode: $episode){__typename,... on Droid{name,primaryFunction},... on Human{name,height}},` +
+		`friends{__typename,... on Droid{name,primaryFunction},... on Human{name,height}},` +
+		`search(text: $text){__typename,... on Human{name,height},... on Droid{name,primaryFunction}}}`
+	if got != want {
+		t.Errorf("\ngot:  %q\nwant: %q", got, want)
+	}
+}
+
 func TestQueryArguments(t *testing.T) {
 	tests := []struct {
 		in   map[string]interface{}
@@ -538,8 +793,13 @@ func TestQueryArguments(t *testing.T) {
 			want: "$optional:[IssueState!]$required:[IssueState!]!",
 		},
 		{
+			// ID is an interface type, so ID("someID") holds a string.
 			in:   map[string]interface{}{"id": ID("someID")},
-			want: "$id:ID!",
+			want: "$id:String!",
+		},
+		{
+			in:   map[string]interface{}{"id": NewID("someID")},
+			want: "$id:ID",
 		},
 		{
 			in:   map[string]interface{}{"ids": []ID{"someID", "anotherID"}},
@@ -549,15 +809,74 @@ func TestQueryArguments(t *testing.T) {
 			in:   map[string]interface{}{"ids": &[]ID{"someID", "anotherID"}},
 			want: `$ids:[ID!]`,
 		},
+		{
+			in: map[string]interface{}{
+				"s": "text",
+				"i": 1,
+				"u": uint8(1),
+				"f": 1.5,
+				"b": (*bool)(nil),
+				"t": time.Time{},
+			},
+			want: "$b:Boolean$f:Float!$i:Int!$s:String!$t:DateTime!$u:Int!",
+		},
+		{
+			in:   map[string]interface{}{"names": []string{"a", "b"}},
+			want: "$names:[String!]!",
+		},
+		{
+			in:   map[string]interface{}{"email": email(""), "emails": []*email{}},
+			want: "$email:Email!$emails:[Email]!",
+		},
+		{
+			in:   map[string]interface{}{"order": (*order)(nil)},
+			want: "$order:IssueOrder",
+		},
 	}
 	for i, tc := range tests {
-		got := queryArguments(tc.in)
+		got, err := queryArguments(tc.in, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
 		if got != tc.want {
 			t.Errorf("test case %d:\n got: %q\nwant: %q", i, got, tc.want)
 		}
 	}
 }
 
+func TestQueryArguments_typeNames(t *testing.T) {
+	types := typeNames{
+		reflect.TypeOf(""):          "ID",
+		reflect.TypeOf(email("")):   "String",
+		reflect.TypeOf(time.Time{}): "Date",
+	}
+	got, err := queryArguments(map[string]interface{}{
+		"id":    "someID",
+		"email": email(""),
+		"since": &time.Time{},
+		"count": 10,
+	}, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "$count:Int!$email:String!$id:ID!$since:Date"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+// email declares its GraphQL type name with a value receiver.
+type email string
+
+func (email) GetGraphQLType() string { return "Email" }
+
+// order declares its GraphQL type name with a pointer receiver.
+type order struct {
+	Field     string
+	Direction string
+}
+
+func (*order) GetGraphQLType() string { return "IssueOrder" }
+
 // Custom GraphQL types for testing.
 type (
 	// DateTime is an ISO-8601 encoded UTC date.
@@ -601,3 +920,21 @@ type AddReactionInput struct {
 	// A unique identifier for the client performing the mutation. (Optional.)
 	ClientMutationID *String `json:"clientMutationId,omitempty"`
 }
+
+func TestSubscriptionClient_WithTypeName(t *testing.T) {
+	sc := NewSubscriptionClient("ws://example.invalid/graphql").
+		WithTypeName(time.Time{}, "Date")
+
+	var s struct {
+		Events struct {
+			Name String
+		} `graphql:"events(since: $since)"`
+	}
+	id, err := sc.Subscribe(&s, map[string]interface{}{"since": time.Time{}}, func(*json.RawMessage, error) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sc.subscriptions[id].query, `subscription ($since:Date!){events(since: $since){name}}`; got != want {
+		t.Errorf("got query: %q, want: %q", got, want)
+	}
+}
@@ -0,0 +1,172 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy configures how failed operations are retried.
+//
+// Network errors, 5xx status codes and 429 Too Many Requests are retried,
+// as well as GraphQL errors whose extension code is listed in ErrorCodes.
+// Other errors, such as those of a request that couldn't be constructed or
+// of a canceled context, aren't.
+// The delay between attempts grows exponentially, with jitter, unless the
+// server specifies it with a Retry-After header. Retrying stops early if the
+// context would be done before the next attempt.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one.
+	// Defaults to 3.
+	MaxAttempts int
+	// MinBackoff is the delay before the first retry. Defaults to 100ms.
+	MinBackoff time.Duration
+	// MaxBackoff is the maximum delay between attempts. Defaults to 10s.
+	MaxBackoff time.Duration
+
+	// ErrorCodes lists the GraphQL error extension codes that are retried,
+	// e.g. "INTERNAL_SERVER_ERROR".
+	ErrorCodes []string
+
+	// RetryMutations enables retrying mutations. Mutations are not retried by
+	// default, since they might have been applied even though the request failed.
+	RetryMutations bool
+}
+
+// WithRetry enables retrying failed operations according to policy.
+func (c *Client) WithRetry(policy RetryPolicy) *Client {
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 3
+	}
+	if policy.MinBackoff <= 0 {
+		policy.MinBackoff = 100 * time.Millisecond
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = 10 * time.Second
+	}
+	c.retryPolicy = &policy
+	return c
+}
+
+// allows reports whether req may be retried.
+func (p *RetryPolicy) allows(req *Request) bool {
+	return req.Type != MutationOperation || p.RetryMutations
+}
+
+// do sends req using send, retrying failed attempts.
+func (p *RetryPolicy) do(ctx context.Context, req *Request, send Handler) (*Response, error) {
+	for attempt := 1; ; attempt++ {
+		attemptReq := req
+		if attempt < p.MaxAttempts {
+			// The data of an attempt that's retried is discarded,
+			// so it mustn't be decoded into the query struct.
+			attemptReq = req.buffered()
+		}
+		resp, err := send(ctx, attemptReq)
+		if attempt >= p.MaxAttempts || ctx.Err() != nil {
+			return resp, err
+		}
+		retry, delay := p.shouldRetry(resp, err)
+		if !retry {
+			return resp, err
+		}
+		if delay == 0 {
+			delay = p.backoff(attempt)
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return resp, err
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return resp, err
+		}
+	}
+}
+
+// shouldRetry reports whether an attempt that resulted in resp and err
+// should be retried, and the delay requested by the server, if any.
+func (p *RetryPolicy) shouldRetry(resp *Response, err error) (retry bool, delay time.Duration) {
+	if resp == nil {
+		// The request couldn't be sent, or no response was received.
+		return isTransportError(err), 0
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return true, retryAfter(resp.Header)
+	case resp.StatusCode >= 500:
+		return true, retryAfter(resp.Header)
+	case err != nil:
+		// The response body may have been cut short.
+		return isTransportError(err), 0
+	}
+	for _, e := range resp.Errors {
+		for _, code := range p.ErrorCodes {
+			if e.Code() == code {
+				return true, 0
+			}
+		}
+	}
+	return false, 0
+}
+
+// isTransportError reports whether err was caused by the network, e.g. the
+// connection being refused or closed before the response was received, as
+// opposed to the request being invalid, or its context done.
+func isTransportError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) {
+		return false
+	}
+	if urlErr, ok := netErr.(*url.Error); ok {
+		// Returned by http.Client for any error, including a request
+		// the transport refused to send.
+		return isTransportError(urlErr.Err)
+	}
+	return true
+}
+
+// backoff returns the delay before the retry following attempt,
+// growing exponentially with jitter.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.MinBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	// Pick a random delay between d/2 and d.
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryAfter returns the delay specified by the Retry-After header of h, if any.
+func retryAfter(h http.Header) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,246 @@
+package graphql_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/InoiOy/go-graphql-client"
+)
+
+func TestClient_WithRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []func(w http.ResponseWriter)
+		mutation  bool
+		policy    graphql.RetryPolicy
+		wantErr   bool
+		wantCalls int
+	}{
+		{
+			name: "server errors",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) { http.Error(w, "unavailable", http.StatusServiceUnavailable) },
+				func(w http.ResponseWriter) { http.Error(w, "bad gateway", http.StatusBadGateway) },
+				okResponse,
+			},
+			wantCalls: 3,
+		},
+		{
+			name: "too many requests",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.Header().Set("Retry-After", "0")
+					http.Error(w, "slow down", http.StatusTooManyRequests)
+				},
+				okResponse,
+			},
+			wantCalls: 2,
+		},
+		{
+			name: "attempts exhausted",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) { http.Error(w, "unavailable", http.StatusServiceUnavailable) },
+				func(w http.ResponseWriter) { http.Error(w, "unavailable", http.StatusServiceUnavailable) },
+				func(w http.ResponseWriter) { http.Error(w, "unavailable", http.StatusServiceUnavailable) },
+			},
+			wantErr:   true,
+			wantCalls: 3,
+		},
+		{
+			name: "client error",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) { http.Error(w, "bad request", http.StatusBadRequest) },
+			},
+			wantErr:   true,
+			wantCalls: 1,
+		},
+		{
+			name: "graphql error code",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					mustWrite(w, `{"errors": [{"message": "try again", "extensions": {"code": "UPSTREAM_TIMEOUT"}}]}`)
+				},
+				okResponse,
+			},
+			policy:    graphql.RetryPolicy{ErrorCodes: []string{"UPSTREAM_TIMEOUT"}},
+			wantCalls: 2,
+		},
+		{
+			name: "mutation",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) { http.Error(w, "unavailable", http.StatusServiceUnavailable) },
+			},
+			mutation:  true,
+			wantErr:   true,
+			wantCalls: 1,
+		},
+		{
+			name: "mutation opt-in",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) { http.Error(w, "unavailable", http.StatusServiceUnavailable) },
+				okResponse,
+			},
+			mutation:  true,
+			policy:    graphql.RetryPolicy{RetryMutations: true},
+			wantCalls: 2,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls int
+			mux := http.NewServeMux()
+			mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+				calls++
+				tc.responses[calls-1](w)
+			})
+			policy := tc.policy
+			policy.MinBackoff = time.Millisecond
+			client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}}).
+				WithRetry(policy)
+
+			var q struct {
+				User struct {
+					Name graphql.String
+				}
+			}
+			var err error
+			if tc.mutation {
+				err = client.Mutate(context.Background(), &q, nil)
+			} else {
+				err = client.Query(context.Background(), &q, nil)
+			}
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("got error: %v, want error: %v", err, tc.wantErr)
+			}
+			if !tc.wantErr && q.User.Name != "Gopher" {
+				t.Errorf("got q.User.Name: %q, want: %q", q.User.Name, "Gopher")
+			}
+			if calls != tc.wantCalls {
+				t.Errorf("got %d calls, want: %d", calls, tc.wantCalls)
+			}
+		})
+	}
+}
+
+func TestClient_WithRetry_contextDeadline(t *testing.T) {
+	var calls int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}}).
+		WithRetry(graphql.RetryPolicy{MaxAttempts: 5})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var q struct {
+		User struct {
+			Name graphql.String
+		}
+	}
+	start := time.Now()
+	err := client.Query(ctx, &q, nil)
+	if err == nil {
+		t.Fatal("got error: nil, want: non-nil")
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls, want: 1", calls)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("gave up after %v, want: immediately", elapsed)
+	}
+}
+
+// Test that the partial data of a retried attempt isn't decoded
+// into the query struct.
+func TestClient_WithRetry_partialData(t *testing.T) {
+	var calls int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Content-Type", "application/json")
+			mustWrite(w, `{"data": {"user": {"name": "Partial"}}, "errors": [{"message": "try again", "extensions": {"code": "UPSTREAM_TIMEOUT"}}]}`)
+			return
+		}
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}}).
+		WithRetry(graphql.RetryPolicy{MaxAttempts: 2, ErrorCodes: []string{"UPSTREAM_TIMEOUT"}, MinBackoff: time.Millisecond})
+
+	var q struct {
+		User struct {
+			Name graphql.String
+		}
+	}
+	if err := client.Query(context.Background(), &q, nil); err == nil {
+		t.Fatal("got error: nil, want: non-nil")
+	}
+	if got, want := q.User.Name, graphql.String(""); got != want {
+		t.Errorf("got q.User.Name: %q, want: %q", got, want)
+	}
+	if calls != 2 {
+		t.Errorf("got %d calls, want: 2", calls)
+	}
+}
+
+func TestClient_WithRetry_transportErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantCalls int
+	}{
+		{name: "connection refused", err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, wantCalls: 2},
+		{name: "unexpected EOF", err: io.ErrUnexpectedEOF, wantCalls: 2},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, wantCalls: 1},
+		{name: "canceled", err: context.Canceled, wantCalls: 1},
+		{name: "other", err: errors.New("unsupported request"), wantCalls: 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls int
+			transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				calls++
+				if calls == 1 {
+					return nil, tc.err
+				}
+				w := httptest.NewRecorder()
+				okResponse(w)
+				return w.Result(), nil
+			})
+			client := graphql.NewClient("/graphql", &http.Client{Transport: transport}).
+				WithRetry(graphql.RetryPolicy{MinBackoff: time.Millisecond})
+
+			var q struct {
+				User struct {
+					Name graphql.String
+				}
+			}
+			err := client.Query(context.Background(), &q, nil)
+			if gotErr, wantErr := err != nil, tc.wantCalls == 1; gotErr != wantErr {
+				t.Errorf("got error: %v, want error: %v", err, wantErr)
+			}
+			if calls != tc.wantCalls {
+				t.Errorf("got %d calls, want: %d", calls, tc.wantCalls)
+			}
+		})
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func okResponse(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	mustWrite(w, `{"data": {"user": {"name": "Gopher"}}}`)
+}
@@ -1,13 +1,11 @@
 package graphql
 
-// Note: These custom types are meant to be used in queries for now.
-// But the plan is to switch to using native Go types (string, int, bool, time.Time, etc.).
-// See https://github.com/shurcooL/githubv4/issues/9 for details.
-//
-// These custom types currently provide documentation, and their use
-// is required for sending outbound queries. However, native Go types
-// can be used for unmarshaling. Once https://github.com/shurcooL/githubv4/issues/9
-// is resolved, native Go types can completely replace these.
+import "io"
+
+// These custom types provide documentation, and can be used in queries and
+// as variables. Native Go types (string, int, bool, time.Time, etc.) can be
+// used as well; see Client.WithTypeName and GraphQLType for how Go types are
+// mapped to GraphQL type names of variables.
 
 type (
 	// Boolean represents true or false values.
@@ -49,3 +47,19 @@ func NewInt(v Int) *Int { return &v }
 
 // NewString is a helper to make a new *String.
 func NewString(v String) *String { return &v }
+
+// Upload represents a file to be uploaded as a variable of type Upload.
+// Operations having Upload variables, anywhere in the variables map,
+// are sent as multipart requests, following the GraphQL multipart request
+// specification https://github.com/jaydenseric/graphql-multipart-request-spec.
+type Upload struct {
+	File        io.Reader
+	FileName    string
+	ContentType string // Defaults to "application/octet-stream".
+}
+
+// MarshalJSON implements json.Marshaler. Uploads are encoded as null
+// in the operation, their content being sent in separate parts.
+func (u Upload) MarshalJSON() ([]byte, error) {
+	return []byte("null"), nil
+}
@@ -79,6 +79,9 @@ type subscription struct {
 	variables map[string]interface{}
 	handler   func(data *json.RawMessage, err error)
 	started   Boolean
+	name      string          // Operation name.
+	ctx       context.Context // Tracing context, if a tracer is set.
+	start     time.Time
 }
 
 // SubscriptionClient is a GraphQL subscription client.
@@ -101,6 +104,8 @@ type SubscriptionClient struct {
 	onError          func(sc *SubscriptionClient, err error) error
 	errorChan        chan error
 	disabledLogTypes []OperationMessageType
+	tracer           Tracer
+	types            typeNames // GraphQL type names of Go types used as variables.
 }
 
 func NewSubscriptionClient(url string) *SubscriptionClient {
@@ -168,6 +173,20 @@ func (sc *SubscriptionClient) WithoutLogTypes(types ...OperationMessageType) *Su
 	return sc
 }
 
+// WithTracer sets the tracer of subscriptions and websocket messages.
+// Each subscription is traced as an operation, from Subscribe to its end.
+func (sc *SubscriptionClient) WithTracer(tracer Tracer) *SubscriptionClient {
+	sc.tracer = tracer
+	return sc
+}
+
+// WithTypeName declares name as the GraphQL type name of the Go type of v,
+// when a value of that type is used as variable. See Client.WithTypeName.
+func (sc *SubscriptionClient) WithTypeName(v interface{}, name string) *SubscriptionClient {
+	sc.types = sc.types.with(v, name)
+	return sc
+}
+
 // WithReadLimit set max size of response message
 func (sc *SubscriptionClient) WithReadLimit(limit int64) *SubscriptionClient {
 	sc.readLimit = limit
@@ -269,29 +288,46 @@ func (sc *SubscriptionClient) sendConnectionInit() (err error) {
 	}
 
 	sc.printLog(msg, GQL_CONNECTION_INIT)
-	return sc.conn.WriteJSON(msg)
+	return sc.sendMessage(msg, nil)
 }
 
 // Subscribe sends start message to server and open a channel to receive data.
 // The handler callback function will receive raw message data or error. If the call return error, onError event will be triggered
 // The function returns subscription ID and error. You can use subscription ID to unsubscribe the subscription
-func (sc *SubscriptionClient) Subscribe(v interface{}, variables map[string]interface{}, handler func(message *json.RawMessage, err error) error) (string, error) {
+func (sc *SubscriptionClient) Subscribe(v interface{}, variables interface{}, handler func(message *json.RawMessage, err error) error) (string, error) {
 	return sc.do(v, variables, handler, "")
 }
 
 // NamedSubscribe sends start message to server and open a channel to receive data, with operation name
-func (sc *SubscriptionClient) NamedSubscribe(name string, v interface{}, variables map[string]interface{}, handler func(message *json.RawMessage, err error) error) (string, error) {
+func (sc *SubscriptionClient) NamedSubscribe(name string, v interface{}, variables interface{}, handler func(message *json.RawMessage, err error) error) (string, error) {
 	return sc.do(v, variables, handler, name)
 }
 
-func (sc *SubscriptionClient) do(v interface{}, variables map[string]interface{}, handler func(message *json.RawMessage, err error) error, name string) (string, error) {
+func (sc *SubscriptionClient) do(v interface{}, variables interface{}, handler func(message *json.RawMessage, err error) error, name string) (string, error) {
 	id := uuid.New().String()
-	query := constructSubscription(v, variables, name)
+	vars, err := variablesMap(variables)
+	if err != nil {
+		return "", err
+	}
+	query, err := constructSubscription(v, vars, name, sc.types)
+	if err != nil {
+		return "", err
+	}
 
 	sub := subscription{
 		query:     query,
-		variables: variables,
+		variables: payloadVariables(vars),
 		handler:   sc.wrapHandler(handler),
+		name:      name,
+	}
+	if sc.tracer != nil {
+		sub.ctx = sc.tracer.StartOperation(context.Background(), TraceEvent{
+			Kind:           TraceOperationStart,
+			OperationName:  name,
+			OperationType:  SubscriptionOperation,
+			SubscriptionID: id,
+		})
+		sub.start = time.Now()
 	}
 
 	// if the websocket client is running, start subscription immediately
@@ -335,7 +371,7 @@ func (sc *SubscriptionClient) startSubscription(id string, sub *subscription) er
 	}
 
 	sc.printLog(msg, GQL_START)
-	if err := sc.conn.WriteJSON(msg); err != nil {
+	if err := sc.sendMessage(msg, sub); err != nil {
 		return err
 	}
 
@@ -402,6 +438,8 @@ func (sc *SubscriptionClient) Run() error {
 				continue
 			}
 
+			sc.traceMessage(TraceWebsocketReceive, message, sc.getSubscription(message.ID), nil)
+
 			switch message.Type {
 			case GQL_ERROR:
 				sc.printLog(message, GQL_ERROR)
@@ -418,11 +456,13 @@ func (sc *SubscriptionClient) Run() error {
 				}
 				var out struct {
 					Data   *json.RawMessage
-					Errors errors
+					Errors Errors
 					//Extensions interface{} // Unused.
 				}
 
+				start := time.Now()
 				err = json.Unmarshal(message.Payload, &out)
+				sc.traceDecode(message, sub, time.Since(start), err)
 				if err != nil {
 					go sub.handler(nil, err)
 					continue
@@ -462,12 +502,22 @@ func (sc *SubscriptionClient) Run() error {
 // Unsubscribe sends stop message to server and close subscription channel
 // The input parameter is subscription ID that is returned from Subscribe function
 func (sc *SubscriptionClient) Unsubscribe(id string) error {
-	_, ok := sc.subscriptions[id]
+	sub, ok := sc.subscriptions[id]
 	if !ok {
 		return fmt.Errorf("subscription id %s doesn't not exist", id)
 	}
 
 	err := sc.stopSubscription(id)
+	if sc.tracer != nil && sub.ctx != nil {
+		sc.tracer.Event(sub.ctx, TraceEvent{
+			Kind:           TraceOperationEnd,
+			OperationName:  sub.name,
+			OperationType:  SubscriptionOperation,
+			Duration:       time.Since(sub.start),
+			Err:            err,
+			SubscriptionID: id,
+		})
+	}
 
 	sc.subscribersMu.Lock()
 	delete(sc.subscriptions, id)
@@ -484,7 +534,7 @@ func (sc *SubscriptionClient) stopSubscription(id string) error {
 		}
 
 		sc.printLog(msg, GQL_STOP)
-		if err := sc.conn.WriteJSON(msg); err != nil {
+		if err := sc.sendMessage(msg, sc.getSubscription(id)); err != nil {
 			return err
 		}
 
@@ -501,12 +551,65 @@ func (sc *SubscriptionClient) terminate() error {
 		}
 
 		sc.printLog(msg, GQL_CONNECTION_TERMINATE)
-		return sc.conn.WriteJSON(msg)
+		return sc.sendMessage(msg, nil)
 	}
 
 	return nil
 }
 
+// getSubscription returns the subscription with id, or nil if none.
+func (sc *SubscriptionClient) getSubscription(id string) *subscription {
+	sc.subscribersMu.Lock()
+	defer sc.subscribersMu.Unlock()
+	return sc.subscriptions[id]
+}
+
+// sendMessage writes msg, of subscription sub if not nil, to the websocket connection.
+func (sc *SubscriptionClient) sendMessage(msg OperationMessage, sub *subscription) error {
+	err := sc.conn.WriteJSON(msg)
+	sc.traceMessage(TraceWebsocketSend, msg, sub, err)
+	return err
+}
+
+// traceMessage reports a websocket message of subscription sub, if not nil, to sc.tracer.
+func (sc *SubscriptionClient) traceMessage(kind TraceEventKind, msg OperationMessage, sub *subscription, err error) {
+	if sc.tracer == nil {
+		return
+	}
+	ctx, event := sc.traceEvent(sub)
+	event.Kind = kind
+	event.Err = err
+	event.Size = int64(len(msg.Payload))
+	event.MessageType = msg.Type
+	event.SubscriptionID = msg.ID
+	sc.tracer.Event(ctx, event)
+}
+
+// traceDecode reports the decoding of msg, of subscription sub, to sc.tracer.
+func (sc *SubscriptionClient) traceDecode(msg OperationMessage, sub *subscription, d time.Duration, err error) {
+	if sc.tracer == nil {
+		return
+	}
+	ctx, event := sc.traceEvent(sub)
+	event.Kind = TraceDecode
+	event.Duration = d
+	event.Err = err
+	event.Size = int64(len(msg.Payload))
+	event.SubscriptionID = msg.ID
+	sc.tracer.Event(ctx, event)
+}
+
+// traceEvent returns the tracing context and base event of subscription sub, if not nil.
+func (sc *SubscriptionClient) traceEvent(sub *subscription) (context.Context, TraceEvent) {
+	ctx := context.Background()
+	event := TraceEvent{OperationType: SubscriptionOperation}
+	if sub != nil && sub.ctx != nil {
+		ctx = sub.ctx
+		event.OperationName = sub.name
+	}
+	return ctx, event
+}
+
 // Reset restart websocket connection and subscriptions
 func (sc *SubscriptionClient) Reset() error {
 	if !sc.isRunning {
@@ -0,0 +1,180 @@
+package graphql
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// TraceEventKind is the kind of a TraceEvent.
+type TraceEventKind uint8
+
+const (
+	// TraceOperationStart is the start of an operation.
+	TraceOperationStart TraceEventKind = iota
+	// TraceOperationEnd is the end of an operation. Duration is the duration
+	// of the whole operation, and Err its error, including GraphQL errors.
+	TraceOperationEnd
+	// TraceHTTPSend is an HTTP request about to be sent. Size is the size of
+	// the request body, or -1 if unknown.
+	TraceHTTPSend
+	// TraceHTTPReceive is the reception of HTTP response headers. Duration is
+	// the time since the request was sent. Size is the content length of the
+	// response, or -1 if unknown.
+	TraceHTTPReceive
+	// TraceDecode is the decoding of a response body. Duration is the time
+	// spent reading and decoding it, and Size the number of bytes read.
+	TraceDecode
+	// TraceWebsocketSend is a websocket message sent by SubscriptionClient.
+	// Size is the size of the message payload.
+	TraceWebsocketSend
+	// TraceWebsocketReceive is a websocket message received by SubscriptionClient.
+	// Size is the size of the message payload.
+	TraceWebsocketReceive
+)
+
+func (k TraceEventKind) String() string {
+	switch k {
+	case TraceOperationStart:
+		return "operation start"
+	case TraceOperationEnd:
+		return "operation end"
+	case TraceHTTPSend:
+		return "http send"
+	case TraceHTTPReceive:
+		return "http receive"
+	case TraceDecode:
+		return "decode"
+	case TraceWebsocketSend:
+		return "websocket send"
+	case TraceWebsocketReceive:
+		return "websocket receive"
+	default:
+		return "unknown"
+	}
+}
+
+// TraceEvent is an event of an operation reported to a Tracer.
+// Fields that don't apply to an event's kind are zero.
+type TraceEvent struct {
+	Kind          TraceEventKind
+	OperationName string
+	OperationType OperationType
+	Duration      time.Duration
+	Err           error
+	Size          int64 // Payload size in bytes.
+
+	StatusCode     int                  // HTTP status code.
+	MessageType    OperationMessageType // Websocket message type.
+	SubscriptionID string               // Websocket subscription ID.
+}
+
+// Tracer receives the events of operations sent by Client and SubscriptionClient,
+// for instance to record them as OpenTelemetry spans and metrics.
+//
+// Methods may be called concurrently for different operations.
+type Tracer interface {
+	// StartOperation is called when an operation starts, with an event of
+	// kind TraceOperationStart. The returned context is used for the rest of
+	// the operation, including HTTP requests, and is passed to Event.
+	StartOperation(ctx context.Context, event TraceEvent) context.Context
+	// Event is called for all other events.
+	Event(ctx context.Context, event TraceEvent)
+}
+
+// WithTracer sets the tracer of c.
+//
+// Operations are traced after middlewares have run, as they were modified
+// by them, and operations answered by a middleware without calling the next
+// handler aren't traced. When batching is enabled, the HTTP events of
+// a batch are reported once, without operation name.
+func (c *Client) WithTracer(tracer Tracer) *Client {
+	c.tracer = tracer
+	return c
+}
+
+// traced wraps h to report the start and end of operations to c.tracer.
+func (c *Client) traced(h Handler) Handler {
+	if c.tracer == nil {
+		return h
+	}
+	return func(ctx context.Context, req *Request) (*Response, error) {
+		ctx = c.tracer.StartOperation(ctx, TraceEvent{
+			Kind:          TraceOperationStart,
+			OperationName: req.OperationName,
+			OperationType: req.Type,
+		})
+		start := time.Now()
+		resp, err := h(ctx, req)
+		event := TraceEvent{
+			Kind:          TraceOperationEnd,
+			OperationName: req.OperationName,
+			OperationType: req.Type,
+			Duration:      time.Since(start),
+			Err:           err,
+		}
+		if err == nil && resp != nil && len(resp.Errors) > 0 {
+			event.Err = resp.Errors
+		}
+		c.tracer.Event(ctx, event)
+		return resp, err
+	}
+}
+
+// trace reports event of req to c.tracer, if any.
+// req is nil for batches of operations.
+func (c *Client) trace(ctx context.Context, req *Request, event TraceEvent) {
+	if c.tracer == nil {
+		return
+	}
+	if req != nil {
+		event.OperationName, event.OperationType = req.OperationName, req.Type
+	}
+	c.tracer.Event(ctx, event)
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// TraceRecorder is a Tracer that records events, for use in tests.
+type TraceRecorder struct {
+	mu     sync.Mutex
+	events []TraceEvent
+}
+
+// StartOperation records event.
+func (r *TraceRecorder) StartOperation(ctx context.Context, event TraceEvent) context.Context {
+	r.Event(ctx, event)
+	return ctx
+}
+
+// Event records event.
+func (r *TraceRecorder) Event(ctx context.Context, event TraceEvent) {
+	r.mu.Lock()
+	r.events = append(r.events, event)
+	r.mu.Unlock()
+}
+
+// Events returns the events recorded so far.
+func (r *TraceRecorder) Events() []TraceEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]TraceEvent(nil), r.events...)
+}
+
+// Reset discards the events recorded so far.
+func (r *TraceRecorder) Reset() {
+	r.mu.Lock()
+	r.events = nil
+	r.mu.Unlock()
+}
@@ -0,0 +1,95 @@
+package graphql_test
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/InoiOy/go-graphql-client"
+)
+
+func TestClient_WithTracer(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {"user": {"name": "Gopher"}}, "errors": [{"message": "partial"}]}`)
+	})
+	recorder := &graphql.TraceRecorder{}
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}}).
+		WithTracer(recorder)
+
+	var q struct {
+		User struct {
+			Name graphql.String
+		}
+	}
+	err := client.NamedQuery(context.Background(), "GetUser", &q, nil)
+	if err == nil {
+		t.Fatal("got error: nil, want: non-nil")
+	}
+
+	events := recorder.Events()
+	var kinds []graphql.TraceEventKind
+	for _, e := range events {
+		kinds = append(kinds, e.Kind)
+		if e.OperationName != "GetUser" || e.OperationType != graphql.QueryOperation {
+			t.Errorf("got %v event of operation %v %q, want: query \"GetUser\"", e.Kind, e.OperationType, e.OperationName)
+		}
+	}
+	want := []graphql.TraceEventKind{
+		graphql.TraceOperationStart,
+		graphql.TraceHTTPSend,
+		graphql.TraceHTTPReceive,
+		graphql.TraceDecode,
+		graphql.TraceOperationEnd,
+	}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Fatalf("got events: %v, want: %v", kinds, want)
+	}
+	if got, want := events[1].Size, int64(len(`{"query":"query GetUser{user{name}}","operationName":"GetUser"}`+"\n")); got != want {
+		t.Errorf("got request size: %d, want: %d", got, want)
+	}
+	if got, want := events[2].StatusCode, http.StatusOK; got != want {
+		t.Errorf("got status code: %d, want: %d", got, want)
+	}
+	if got, want := events[3].Size, int64(len(`{"data": {"user": {"name": "Gopher"}}, "errors": [{"message": "partial"}]}`)); got != want {
+		t.Errorf("got response size: %d, want: %d", got, want)
+	}
+	if got, want := events[4].Err, error(graphql.Errors{{Message: "partial"}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got operation error: %v, want: %v", got, want)
+	}
+}
+
+// Test that operations are traced as middlewares modified them.
+func TestClient_WithTracer_middleware(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {"user": {"name": "Gopher"}}}`)
+	})
+	recorder := &graphql.TraceRecorder{}
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}}).
+		WithMiddleware(func(next graphql.Handler) graphql.Handler {
+			return func(ctx context.Context, req *graphql.Request) (*graphql.Response, error) {
+				req.OperationName = "Renamed"
+				return next(ctx, req)
+			}
+		}).
+		WithTracer(recorder)
+
+	var q struct {
+		User struct {
+			Name graphql.String
+		}
+	}
+	err := client.NamedQuery(context.Background(), "GetUser", &q, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range recorder.Events() {
+		if got, want := e.OperationName, "Renamed"; got != want {
+			t.Errorf("got %v event of operation %q, want: %q", e.Kind, got, want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// upload is an Upload found in variables, along with its object path
+// in the operation, e.g. "variables.input.files.0".
+type upload struct {
+	path string
+	*Upload
+}
+
+var (
+	uploadType   = reflect.TypeOf(Upload{})
+	variableType = reflect.TypeOf(Variable{})
+)
+
+// findUploads returns the uploads found anywhere in variables,
+// including nested in input objects and lists.
+func findUploads(variables map[string]interface{}) []upload {
+	var uploads []upload
+	findUploadsIn(reflect.ValueOf(variables), "variables", &uploads)
+	return uploads
+}
+
+func findUploadsIn(v reflect.Value, path string, uploads *[]upload) {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if !v.IsNil() {
+			findUploadsIn(v.Elem(), path, uploads)
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, k := range keys {
+			findUploadsIn(v.MapIndex(k), path+"."+k.String(), uploads)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			findUploadsIn(v.Index(i), path+"."+strconv.Itoa(i), uploads)
+		}
+	case reflect.Struct:
+		if v.Type() == variableType {
+			findUploadsIn(v.Field(0), path, uploads)
+			return
+		}
+		if v.Type() == uploadType {
+			u := v.Interface().(Upload)
+			*uploads = append(*uploads, upload{path: path, Upload: &u})
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if f.PkgPath != "" && !f.Anonymous {
+				// Skip unexported field, like encoding/json does.
+				continue
+			}
+			name := f.Name
+			if tag, ok := f.Tag.Lookup("json"); ok {
+				if tag == "-" {
+					continue
+				}
+				if i := strings.Index(tag, ","); i != -1 {
+					tag = tag[:i]
+				}
+				if tag != "" {
+					name = tag
+				} else if f.Anonymous {
+					// Fields of embedded structs are promoted into the parent object.
+					findUploadsIn(v.Field(i), path, uploads)
+					continue
+				}
+			} else if f.Anonymous {
+				findUploadsIn(v.Field(i), path, uploads)
+				continue
+			}
+			findUploadsIn(v.Field(i), path+"."+name, uploads)
+		}
+	}
+}
+
+// postMultipart sends req as a multipart request, with the content
+// of uploads in separate parts, following the GraphQL multipart request specification.
+func (c *Client) postMultipart(ctx context.Context, req *Request, uploads []upload) (*Response, error) {
+	operations, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	fileMap := make(map[string][]string, len(uploads))
+	for i, u := range uploads {
+		fileMap[strconv.Itoa(i)] = []string{u.path}
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeMultipart(mw, operations, fileMap, uploads))
+	}()
+
+	httpReq, err := http.NewRequest(http.MethodPost, c.url, pr)
+	if err != nil {
+		pr.Close()
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, err := c.roundTrip(ctx, req, httpReq)
+	pr.Close() // Stop the writer, if the request failed before consuming the whole body.
+	return resp, err
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// writeMultipart writes the parts of a multipart request to mw, and closes it.
+func writeMultipart(mw *multipart.Writer, operations []byte, fileMap map[string][]string, uploads []upload) error {
+	err := mw.WriteField("operations", string(operations))
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(fileMap)
+	if err != nil {
+		return err
+	}
+	err = mw.WriteField("map", string(b))
+	if err != nil {
+		return err
+	}
+	for i, u := range uploads {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%d"; filename="%s"`, i, quoteEscaper.Replace(u.FileName)))
+		contentType := u.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		h.Set("Content-Type", contentType)
+		w, err := mw.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		if u.File != nil {
+			_, err = io.Copy(w, u.File)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return mw.Close()
+}
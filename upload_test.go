@@ -0,0 +1,77 @@
+package graphql_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/InoiOy/go-graphql-client"
+)
+
+func TestClient_Mutate_upload(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		if err := req.ParseMultipartForm(1 << 20); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := req.FormValue("operations"), `{"query":"mutation ($input:AttachInput!){attach(input: $input){count}}","variables":{"input":{"issue":"1","files":[null,null],"cover":null}}}`; got != want {
+			t.Errorf("got operations: %s, want: %s", got, want)
+		}
+		if got, want := req.FormValue("map"), `{"0":["variables.input.files.0"],"1":["variables.input.files.1"],"2":["variables.input.cover"]}`; got != want {
+			t.Errorf("got map: %s, want: %s", got, want)
+		}
+		for name, want := range map[string]string{"0": "first", "1": "second", "2": "cover"} {
+			f, h, err := req.FormFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := mustRead(f); got != want {
+				t.Errorf("got file %s content: %q, want: %q", name, got, want)
+			}
+			if got, want := h.Filename, want+".txt"; got != want {
+				t.Errorf("got file %s name: %q, want: %q", name, got, want)
+			}
+			if got, want := h.Header.Get("Content-Type"), "text/plain"; got != want {
+				t.Errorf("got file %s content type: %q, want: %q", name, got, want)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {"attach": {"count": 3}}}`)
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}})
+
+	type AttachInput struct {
+		Issue graphql.ID       `json:"issue"`
+		Files []graphql.Upload `json:"files"`
+		Cover *graphql.Upload  `json:"cover"`
+		Note  *graphql.String  `json:"note,omitempty"`
+	}
+	file := func(content string) graphql.Upload {
+		return graphql.Upload{
+			File:        ioutil.NopCloser(strings.NewReader(content)),
+			FileName:    content + ".txt",
+			ContentType: "text/plain",
+		}
+	}
+	cover := file("cover")
+	var m struct {
+		Attach struct {
+			Count graphql.Int
+		} `graphql:"attach(input: $input)"`
+	}
+	err := client.Mutate(context.Background(), &m, map[string]interface{}{
+		"input": AttachInput{
+			Issue: "1",
+			Files: []graphql.Upload{file("first"), file("second")},
+			Cover: &cover,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.Attach.Count, graphql.Int(3); got != want {
+		t.Errorf("got m.Attach.Count: %v, want: %v", got, want)
+	}
+}
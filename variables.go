@@ -0,0 +1,219 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/InoiOy/go-graphql-client/ident"
+)
+
+// Variable is a variable value with an explicit GraphQL type, overriding
+// the type derived from the Go type of Value. It allows to state the exact
+// nullability of lists and their elements, e.g.
+//
+//	variables := map[string]interface{}{
+//		"ids":    graphql.Variable{Value: ids, Type: "[ID]!"},
+//		"matrix": graphql.Variable{Value: matrix, Type: "[[Int!]]"},
+//	}
+//
+// If Type is empty, it's derived from the Go type of Value.
+//
+// Default, if not empty, is the default value of the variable in the
+// operation, as a GraphQL literal. A variable with a default whose Value
+// is nil, or a nil pointer, slice or map, is left out of the request
+// payload, so that the server uses the default:
+//
+//	"first": graphql.Variable{Value: (*int)(nil), Default: "10"}
+type Variable struct {
+	Value   interface{}
+	Type    string // GraphQL type, e.g. "[String!]".
+	Default string // GraphQL literal, e.g. "10" or "[OPEN CLOSED]".
+}
+
+// MarshalJSON encodes the value of v.
+func (v Variable) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.Value)
+}
+
+// variableValue returns the value of variable v, without its explicit type, if any.
+func variableValue(v interface{}) interface{} {
+	if tv, ok := v.(Variable); ok {
+		return tv.Value
+	}
+	return v
+}
+
+// omitted reports whether variable v is left out of the request payload.
+func omitted(v interface{}) bool {
+	tv, ok := v.(Variable)
+	if !ok || tv.Default == "" {
+		return false
+	}
+	value := reflect.ValueOf(tv.Value)
+	switch value.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.Ptr, reflect.Slice, reflect.Map:
+		return value.IsNil()
+	default:
+		return false
+	}
+}
+
+// payloadVariables returns the variables sent in the request payload,
+// without those left out for the server to use their default value.
+func payloadVariables(variables map[string]interface{}) map[string]interface{} {
+	n := 0
+	for _, v := range variables {
+		if omitted(v) {
+			n++
+		}
+	}
+	if n == 0 {
+		return variables
+	}
+	payload := make(map[string]interface{}, len(variables)-n)
+	for name, v := range variables {
+		if !omitted(v) {
+			payload[name] = v
+		}
+	}
+	return payload
+}
+
+// parseType returns GraphQL type t without insignificant whitespace,
+// or an error if it isn't a valid type reference, e.g. "[[Int!]]!".
+func parseType(t string) (string, error) {
+	typ := strings.Join(strings.Fields(t), "")
+	i, ok := parseTypeAt(typ, 0)
+	if !ok || i != len(typ) {
+		return "", fmt.Errorf("invalid GraphQL type %q", t)
+	}
+	return typ, nil
+}
+
+// parseTypeAt parses the type starting at index i of t,
+// and returns the index after it.
+func parseTypeAt(t string, i int) (int, bool) {
+	switch {
+	case i < len(t) && t[i] == '[':
+		j, ok := parseTypeAt(t, i+1)
+		if !ok || j == len(t) || t[j] != ']' {
+			return 0, false
+		}
+		i = j + 1
+	case i < len(t) && isNameChar(t[i]) && (t[i] < '0' || t[i] > '9'):
+		for i < len(t) && isNameChar(t[i]) {
+			i++
+		}
+	default:
+		return 0, false
+	}
+	if i < len(t) && t[i] == '!' {
+		i++
+	}
+	return i, true
+}
+
+// variablesMap converts the variables of an operation to a map.
+//
+// variables is either nil, a map with string keys, or a struct (or pointer
+// to struct) whose exported fields are the variables. The variable name of
+// a struct field is given by its graphql tag, defaulting to the lowerCamelCase
+// field name, and its GraphQL type can be overridden with a type option,
+// e.g. `graphql:"id,type=ID!"`. A default option, which must come last,
+// declares a default value, e.g. `graphql:"first,default=10"`. Fields tagged
+// `graphql:"-"` are ignored, and embedded structs without tag have their
+// fields promoted.
+func variablesMap(variables interface{}) (map[string]interface{}, error) {
+	switch variables := variables.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		return variables, nil
+	}
+	v := reflect.ValueOf(variables)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	switch {
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+		m := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			m[k.String()] = v.MapIndex(k).Interface()
+		}
+		return m, nil
+	case v.Kind() == reflect.Struct:
+		m := make(map[string]interface{})
+		if err := structVariables(v, m); err != nil {
+			return nil, err
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("variables must be a map or a struct, not %T", variables)
+	}
+}
+
+// structVariables adds the fields of struct v to variables m.
+func structVariables(v reflect.Value, m map[string]interface{}) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup("graphql")
+		if tag == "-" {
+			continue
+		}
+		if f.Anonymous && !ok {
+			fv := v.Field(i)
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				if err := structVariables(fv, m); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue // Unexported.
+		}
+		options := strings.Split(tag, ",")
+		name := strings.TrimSpace(options[0])
+		if name == "" {
+			name = ident.ParseMixedCaps(f.Name).ToLowerCamelCase()
+		}
+		if _, ok := m[name]; ok {
+			return fmt.Errorf("variable %q is defined more than once", name)
+		}
+		variable := Variable{Value: v.Field(i).Interface()}
+		for j := 1; j < len(options); j++ {
+			option := strings.TrimSpace(options[j])
+			switch {
+			case strings.HasPrefix(option, "type="):
+				variable.Type = strings.TrimPrefix(option, "type=")
+			case strings.HasPrefix(option, "default="):
+				// The default value may contain commas, e.g. "[OPEN, CLOSED]".
+				rest := strings.TrimSpace(strings.Join(options[j:], ","))
+				variable.Default = strings.TrimSpace(strings.TrimPrefix(rest, "default="))
+				j = len(options)
+			default:
+				return fmt.Errorf("variable %q: unknown option %q", name, option)
+			}
+		}
+		if variable.Type == "" && variable.Default == "" {
+			m[name] = variable.Value
+		} else {
+			m[name] = variable
+		}
+	}
+	return nil
+}
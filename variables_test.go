@@ -0,0 +1,175 @@
+package graphql_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/InoiOy/go-graphql-client"
+)
+
+func TestClient_Query_structVariables(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		body := mustRead(req.Body)
+		if got, want := body, `{"query":"query ($first:Int$id:ID!$login:String!){user(id: $id, login: $login){followers(first: $first){totalCount}}}","variables":{"first":null,"id":"MDQ6VXNlcjE=","login":"gopher"}}`+"\n"; got != want {
+			t.Errorf("got body: %v, want %v", got, want)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {"user": {"followers": {"totalCount": 42}}}}`)
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}})
+
+	type pagination struct {
+		First *graphql.Int
+	}
+	var q struct {
+		User struct {
+			Followers struct {
+				TotalCount graphql.Int
+			} `graphql:"followers(first: $first)"`
+		} `graphql:"user(id: $id, login: $login)"`
+	}
+	variables := struct {
+		ID    string `graphql:"id,type=ID!"`
+		Login graphql.String
+		pagination
+		Ignored string `graphql:"-"`
+	}{
+		ID:    "MDQ6VXNlcjE=",
+		Login: "gopher",
+	}
+	err := client.Query(context.Background(), &q, &variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := q.User.Followers.TotalCount, graphql.Int(42); got != want {
+		t.Errorf("got q.User.Followers.TotalCount: %v, want: %v", got, want)
+	}
+}
+
+func TestConstructQuery_typedVariables(t *testing.T) {
+	var q struct {
+		Nodes []struct {
+			ID graphql.ID
+		} `graphql:"nodes(ids: $ids, matrix: $matrix, tags: $tags)"`
+	}
+	got, definitions, err := graphql.ConstructQuery(&q, map[string]interface{}{
+		"ids":    graphql.Variable{Value: []graphql.ID{"a", "b"}, Type: "[ID]!"},
+		"matrix": graphql.Variable{Value: [][]int{{1}}, Type: "[ [Int!] ]"},
+		"tags":   graphql.Variable{Value: []string{"go"}},
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `query ($ids:[ID]!$matrix:[[Int!]]$tags:[String!]!){nodes(ids: $ids, matrix: $matrix, tags: $tags){id}}`; got != want {
+		t.Errorf("got query: %q, want: %q", got, want)
+	}
+	if got, want := definitions[1], (graphql.VariableDefinition{Name: "matrix", Type: "[[Int!]]"}); got != want {
+		t.Errorf("got definition: %+v, want: %+v", got, want)
+	}
+}
+
+func TestClient_Query_defaultVariables(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, req *http.Request) {
+		body := mustRead(req.Body)
+		if got, want := body, `{"query":"query ($first:Int=10$login:String!$states:[IssueState!]=[OPEN, CLOSED]){user(login: $login){issues(first: $first, states: $states){totalCount}}}","variables":{"login":"gopher"}}`+"\n"; got != want {
+			t.Errorf("got body: %v, want %v", got, want)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		mustWrite(w, `{"data": {"user": {"issues": {"totalCount": 3}}}}`)
+	})
+	client := graphql.NewClient("/graphql", &http.Client{Transport: localRoundTripper{handler: mux}})
+
+	type IssueState string
+	var q struct {
+		User struct {
+			Issues struct {
+				TotalCount graphql.Int
+			} `graphql:"issues(first: $first, states: $states)"`
+		} `graphql:"user(login: $login)"`
+	}
+	variables := struct {
+		Login  string
+		First  *int         `graphql:"first,default=10"`
+		States []IssueState `graphql:"states,type=[IssueState!],default=[OPEN, CLOSED]"`
+	}{
+		Login: "gopher",
+	}
+	err := client.Query(context.Background(), &q, variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := q.User.Issues.TotalCount, graphql.Int(3); got != want {
+		t.Errorf("got q.User.Issues.TotalCount: %v, want: %v", got, want)
+	}
+}
+
+func TestConstructQuery_defaultVariables(t *testing.T) {
+	var q struct {
+		Search struct {
+			IssueCount graphql.Int
+		} `graphql:"search(query: $query, first: $first)"`
+	}
+	got, definitions, err := graphql.ConstructQuery(&q, map[string]interface{}{
+		"query": graphql.Variable{Value: "is:open", Default: `"is:issue"`},
+		"first": graphql.Variable{Type: "Int", Default: "10"},
+	}, "Search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `query Search($first:Int=10$query:String!="is:issue"){search(query: $query, first: $first){issueCount}}`; got != want {
+		t.Errorf("got query: %q, want: %q", got, want)
+	}
+	if got, want := definitions[0], (graphql.VariableDefinition{Name: "first", Type: "Int", Default: "10"}); got != want {
+		t.Errorf("got definition: %+v, want: %+v", got, want)
+	}
+}
+
+func TestConstructQuery_structVariablesErrors(t *testing.T) {
+	var q struct {
+		Viewer struct {
+			Login graphql.String
+		}
+	}
+	tests := []struct {
+		variables interface{}
+		want      string
+	}{
+		{
+			variables: struct {
+				ID string `graphql:"id,required"`
+			}{},
+			want: `variable "id": unknown option "required"`,
+		},
+		{
+			variables: struct {
+				A string `graphql:"id"`
+				B string `graphql:"id"`
+			}{},
+			want: `variable "id" is defined more than once`,
+		},
+		{
+			variables: struct {
+				IDs []string `graphql:"ids,type=[ID!"`
+			}{},
+			want: `variable "ids": invalid GraphQL type "[ID!"`,
+		},
+		{
+			variables: map[string]interface{}{"n": graphql.Variable{Value: 1, Type: "Int!!"}},
+			want:      `variable "n": invalid GraphQL type "Int!!"`,
+		},
+		{
+			variables: []string{"id"},
+			want:      `variables must be a map or a struct, not []string`,
+		},
+	}
+	for i, tc := range tests {
+		_, _, err := graphql.ConstructQuery(&q, tc.variables, "")
+		if got := fmt.Sprint(err); got != tc.want {
+			t.Errorf("test case %d: got error: %v, want: %v", i, got, tc.want)
+		}
+	}
+}